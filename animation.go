@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"time"
+
+	"golang.org/x/image/webp"
+)
+
+// errNotAnimated is returned by decodeAnimation for still images.
+var errNotAnimated = errors.New("not animated")
+
+// maxAnimationBytes bounds the memory used by the frames of an animation.
+// Frames are kept fully composited, so long animations add up quickly.
+const maxAnimationBytes = 512 << 20
+
+// Browsers treat shorter delays as a mistake, and so do we.
+const (
+	minFrameDelay     = 20 * time.Millisecond
+	defaultFrameDelay = 100 * time.Millisecond
+)
+
+type frame struct {
+	img   *image.RGBA
+	delay time.Duration
+}
+
+type animation struct {
+	frames []frame
+	// plays is how often the animation is played, 0 means forever.
+	plays int
+}
+
+// isAnimatable reports whether pictures of format may be animated.
+func isAnimatable(format string) bool {
+	return format == "gif" || format == "png" || format == "webp"
+}
+
+// decodeAnimation returns the frames of the animated image in data.
+func decodeAnimation(data []byte, format string) (*animation, error) {
+	switch format {
+	case "gif":
+		return gifAnimation(data)
+	case "png":
+		return apngAnimation(data)
+	case "webp":
+		return webpAnimation(data)
+	}
+	return nil, errNotAnimated
+}
+
+// canvas composes the frames of an animation.
+type canvas struct {
+	img  *image.RGBA
+	anim animation
+	size int
+}
+
+func newCanvas(w, h int) *canvas {
+	return &canvas{img: image.NewRGBA(image.Rect(0, 0, w, h))}
+}
+
+// add draws src at r and appends the result as a frame.
+// Afterwards, r is disposed of by restoring it to transparency (background)
+// or to what it was before (previous).
+func (c *canvas) add(src image.Image, r image.Rectangle, op draw.Op, delay time.Duration, background, previous bool) error {
+	c.size += len(c.img.Pix)
+	if c.size > maxAnimationBytes {
+		return errors.New("animation too large")
+	}
+	var saved *image.RGBA
+	if previous {
+		saved = clone(c.img)
+	}
+	draw.Draw(c.img, r, src, src.Bounds().Min, op)
+	c.anim.frames = append(c.anim.frames, frame{img: clone(c.img), delay: max(delay, minFrameDelay)})
+
+	switch {
+	case previous:
+		c.img = saved
+	case background:
+		draw.Draw(c.img, r, image.Transparent, image.Point{}, draw.Src)
+	}
+	return nil
+}
+
+// result returns the animation, or errNotAnimated if there is only a single frame.
+func (c *canvas) result(plays int) (*animation, error) {
+	if len(c.anim.frames) < 2 {
+		return nil, errNotAnimated
+	}
+	c.anim.plays = plays
+	return &c.anim, nil
+}
+
+func clone(img *image.RGBA) *image.RGBA {
+	out := *img
+	out.Pix = bytes.Clone(img.Pix)
+	return &out
+}
+
+func gifAnimation(data []byte) (*animation, error) {
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	c := newCanvas(g.Config.Width, g.Config.Height)
+	for i, img := range g.Image {
+		delay := time.Duration(g.Delay[i]) * 10 * time.Millisecond
+		if delay <= 10*time.Millisecond {
+			delay = defaultFrameDelay
+		}
+		var disposal byte
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+		err := c.add(img, img.Bounds(), draw.Over, delay,
+			disposal == gif.DisposalBackground, disposal == gif.DisposalPrevious)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// LoopCount counts repetitions, -1 meaning none.
+	plays := 0
+	if g.LoopCount != 0 {
+		plays = max(g.LoopCount+1, 1)
+	}
+	return c.result(plays)
+}
+
+// apngAnimation decodes animated PNGs by rewriting every frame
+// into a PNG of its own.
+// See https://wiki.mozilla.org/APNG_Specification
+func apngAnimation(data []byte) (*animation, error) {
+	const sig = "\x89PNG\r\n\x1a\n"
+	if len(data) < len(sig) || string(data[:len(sig)]) != sig {
+		return nil, errNotAnimated
+	}
+
+	type apngFrame struct {
+		x, y, w, h     int
+		delay          time.Duration
+		dispose, blend byte
+		data           []byte
+	}
+	var (
+		ihdr   []byte
+		shared [][]byte // chunks every frame needs, like palettes
+		frames []*apngFrame
+		cur    *apngFrame
+		plays  = -1
+	)
+
+	rest := data[len(sig):]
+	for len(rest) >= 12 {
+		n := binary.BigEndian.Uint32(rest)
+		if uint64(n)+12 > uint64(len(rest)) {
+			return nil, errors.New("apng: truncated chunk")
+		}
+		typ, body := string(rest[4:8]), rest[8:8+n]
+		chunk := rest[:12+n]
+		rest = rest[12+n:]
+
+		switch typ {
+		case "IHDR":
+			ihdr = body
+		case "acTL":
+			if len(body) < 8 {
+				return nil, errors.New("apng: invalid acTL")
+			}
+			plays = int(binary.BigEndian.Uint32(body[4:]))
+		case "fcTL":
+			if len(body) < 26 {
+				return nil, errors.New("apng: invalid fcTL")
+			}
+			num, den := binary.BigEndian.Uint16(body[20:]), binary.BigEndian.Uint16(body[22:])
+			if den == 0 {
+				den = 100
+			}
+			cur = &apngFrame{
+				w:       int(binary.BigEndian.Uint32(body[4:])),
+				h:       int(binary.BigEndian.Uint32(body[8:])),
+				x:       int(binary.BigEndian.Uint32(body[12:])),
+				y:       int(binary.BigEndian.Uint32(body[16:])),
+				delay:   time.Duration(num) * time.Second / time.Duration(den),
+				dispose: body[24],
+				blend:   body[25],
+			}
+			frames = append(frames, cur)
+		case "IDAT":
+			// Without a preceding fcTL, the default image is not part
+			// of the animation.
+			if cur != nil {
+				cur.data = append(cur.data, body...)
+			}
+		case "fdAT":
+			if cur != nil && len(body) >= 4 {
+				cur.data = append(cur.data, body[4:]...)
+			}
+		case "IEND":
+			rest = nil
+		default:
+			if len(frames) == 0 && ihdr != nil {
+				shared = append(shared, chunk)
+			}
+		}
+	}
+	if plays < 0 || ihdr == nil || len(ihdr) < 13 {
+		return nil, errNotAnimated
+	}
+
+	c := newCanvas(int(binary.BigEndian.Uint32(ihdr)), int(binary.BigEndian.Uint32(ihdr[4:])))
+	for i, f := range frames {
+		hdr := bytes.Clone(ihdr)
+		binary.BigEndian.PutUint32(hdr, uint32(f.w))
+		binary.BigEndian.PutUint32(hdr[4:], uint32(f.h))
+
+		var b bytes.Buffer
+		b.WriteString(sig)
+		writeChunk(&b, "IHDR", hdr)
+		for _, s := range shared {
+			b.Write(s)
+		}
+		writeChunk(&b, "IDAT", f.data)
+		writeChunk(&b, "IEND", nil)
+		img, err := png.Decode(&b)
+		if err != nil {
+			return nil, err
+		}
+
+		op := draw.Src
+		if f.blend == 1 {
+			op = draw.Over
+		}
+		r := image.Rect(f.x, f.y, f.x+f.w, f.y+f.h)
+		// There is nothing to go back to after the first frame,
+		// so the specification has it treated as background.
+		background := f.dispose == 1 || i == 0 && f.dispose == 2
+		if err := c.add(img, r, op, f.delay, background, i > 0 && f.dispose == 2); err != nil {
+			return nil, err
+		}
+	}
+	return c.result(plays)
+}
+
+func writeChunk(b *bytes.Buffer, typ string, data []byte) {
+	binary.Write(b, binary.BigEndian, uint32(len(data)))
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(typ))
+	crc.Write(data)
+	b.WriteString(typ)
+	b.Write(data)
+	binary.Write(b, binary.BigEndian, crc.Sum32())
+}
+
+// webpAnimation decodes animated WebPs by rewriting every frame
+// into a WebP of its own.
+// See https://developers.google.com/speed/webp/docs/riff_container
+func webpAnimation(data []byte) (*animation, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, errNotAnimated
+	}
+
+	var c *canvas
+	plays := -1
+	for typ, body := range riffChunks(data[12:]) {
+		switch typ {
+		case "VP8X":
+			if len(body) < 10 || body[0]&0x02 == 0 {
+				return nil, errNotAnimated
+			}
+			c = newCanvas(1+int(uint24(body[4:])), 1+int(uint24(body[7:])))
+		case "ANIM":
+			if len(body) < 6 {
+				return nil, errors.New("webp: invalid ANIM")
+			}
+			plays = int(binary.LittleEndian.Uint16(body[4:]))
+		case "ANMF":
+			if c == nil || len(body) < 16 {
+				return nil, errors.New("webp: invalid ANMF")
+			}
+			x, y := 2*int(uint24(body)), 2*int(uint24(body[3:]))
+			w, h := 1+int(uint24(body[6:])), 1+int(uint24(body[9:]))
+			delay := time.Duration(uint24(body[12:])) * time.Millisecond
+			flags := body[15]
+
+			img, err := webp.Decode(bytes.NewReader(webpFrame(body[16:], w, h)))
+			if err != nil {
+				return nil, err
+			}
+			op := draw.Over
+			if flags&0x02 != 0 {
+				op = draw.Src
+			}
+			r := image.Rect(x, y, x+w, y+h)
+			if err := c.add(img, r, op, delay, flags&0x01 != 0, false); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if c == nil || plays < 0 {
+		return nil, errNotAnimated
+	}
+	return c.result(plays)
+}
+
+// webpFrame wraps the chunks of an animation frame into a still WebP.
+func webpFrame(chunks []byte, w, h int) []byte {
+	var body bytes.Buffer
+	body.WriteString("WEBP")
+	for typ, data := range riffChunks(chunks) {
+		switch typ {
+		case "ALPH":
+			// Alpha channels are only allowed in extended files.
+			vp8x := make([]byte, 10)
+			vp8x[0] = 0x10
+			putUint24(vp8x[4:], uint32(w-1))
+			putUint24(vp8x[7:], uint32(h-1))
+			writeRIFFChunk(&body, "VP8X", vp8x)
+			writeRIFFChunk(&body, typ, data)
+		case "VP8 ", "VP8L":
+			writeRIFFChunk(&body, typ, data)
+		}
+	}
+
+	var b bytes.Buffer
+	b.WriteString("RIFF")
+	binary.Write(&b, binary.LittleEndian, uint32(body.Len()))
+	b.Write(body.Bytes())
+	return b.Bytes()
+}
+
+// riffChunks iterates over the chunks in data, stopping at malformed ones.
+func riffChunks(data []byte) func(yield func(string, []byte) bool) {
+	return func(yield func(string, []byte) bool) {
+		for len(data) >= 8 {
+			n := binary.LittleEndian.Uint32(data[4:])
+			if uint64(n) > uint64(len(data)-8) {
+				return
+			}
+			if !yield(string(data[:4]), data[8:8+n]) {
+				return
+			}
+			// Chunks are padded to an even size.
+			data = data[min(8+int(n)+int(n&1), len(data)):]
+		}
+	}
+}
+
+func writeRIFFChunk(b *bytes.Buffer, typ string, data []byte) {
+	b.WriteString(typ)
+	binary.Write(b, binary.LittleEndian, uint32(len(data)))
+	b.Write(data)
+	if len(data)%2 == 1 {
+		b.WriteByte(0)
+	}
+}
+
+func uint24(b []byte) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16
+}
+
+func putUint24(b []byte, v uint32) {
+	b[0], b[1], b[2] = byte(v), byte(v>>8), byte(v>>16)
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// archiveExts lists filename extensions of archives treated as directories.
+var archiveExts = []string{".zip", ".cbz", ".tar", ".cbt", ".tar.gz", ".tgz"}
+
+func isArchive(p string) bool {
+	p = strings.ToLower(p)
+	for _, ext := range archiveExts {
+		if strings.HasSuffix(p, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+func isZip(p string) bool {
+	ext := strings.ToLower(filepath.Ext(p))
+	return ext == ".zip" || ext == ".cbz"
+}
+
+// archive gives access to the entries of an archive without having to
+// search through it again.
+type archive struct {
+	path string
+	// info describes the archive file, deciding whether cached
+	// metadata of its entries is still valid.
+	info fs.FileInfo
+	// zip is kept open for zip archives.
+	zip *zip.ReadCloser
+	// data is the file tar entries are read from. For gzipped archives,
+	// it is a decompressed copy, so entries can be read without
+	// decompressing everything before them again.
+	data string
+	temp bool
+}
+
+// archiveEntry locates a picture inside an archive.
+type archiveEntry struct {
+	arc *archive
+	zip *zip.File
+	// offset and size locate the data of a tar entry.
+	offset, size int64
+}
+
+// readArchive lists the images stored in the archive at p.
+// They are loaded like other pictures once needed.
+// The archive has to be closed after use.
+func readArchive(p string, pf *pathFilter) (*archive, []*picture, []skippedFile, error) {
+	absPath, err := filepath.Abs(p)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	info, err := os.Stat(p)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	arc := &archive{path: absPath, info: info, data: absPath}
+
+	var pics []*picture
+	var skipped []skippedFile
+	addEntry := func(name string, size int64, e *archiveEntry) {
+		virt := filepath.Join(absPath, filepath.FromSlash(name))
+		if reason := archiveEntryReason(name, pf); reason != "" {
+			skipped = append(skipped, skippedFile{path: virt, reason: reason})
+			return
+		}
+		pics = append(pics, &picture{
+			name:    name,
+			path:    virt,
+			size:    size,
+			archive: absPath,
+			entry:   e,
+		})
+	}
+
+	if isZip(p) {
+		arc.zip, err = zip.OpenReader(p)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		for _, f := range arc.zip.File {
+			if !f.FileInfo().IsDir() {
+				addEntry(f.Name, int64(f.UncompressedSize64), &archiveEntry{arc: arc, zip: f})
+			}
+		}
+	} else {
+		err := arc.walkTar(func(hdr *tar.Header, offset int64) {
+			addEntry(hdr.Name, hdr.Size, &archiveEntry{arc: arc, offset: offset, size: hdr.Size})
+		})
+		if err != nil {
+			arc.close()
+			return nil, nil, nil, err
+		}
+	}
+
+	slices.SortFunc(pics, func(a, b *picture) int {
+		return strings.Compare(a.name, b.name)
+	})
+	return arc, pics, skipped, nil
+}
+
+// close releases the archive, removing decompressed copies.
+func (arc *archive) close() error {
+	if arc.zip != nil {
+		return arc.zip.Close()
+	}
+	if arc.temp {
+		return os.Remove(arc.data)
+	}
+	return nil
+}
+
+// archiveEntryReason is like [pathFilter.skipReason] for archive entries.
+// Hidden entries (including macOS resource forks) are always skipped.
+func archiveEntryReason(name string, pf *pathFilter) string {
+	for part := range strings.SplitSeq(path.Clean(name), "/") {
+		if part == "__MACOSX" || !pf.hidden && isHidden(part) {
+			return "hidden"
+		}
+	}
+	return pf.skipReason(name)
+}
+
+// walkTar calls fn for every regular file in the (possibly gzipped)
+// tar archive, along with the offset of its data in arc.data.
+func (arc *archive) walkTar(fn func(hdr *tar.Header, offset int64)) error {
+	f, err := os.Open(arc.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Without compression, the tar reader seeks past the entries.
+	var r io.Reader = f
+	pos := func() (int64, error) {
+		return f.Seek(0, io.SeekCurrent)
+	}
+	lower := strings.ToLower(arc.path)
+	if strings.HasSuffix(lower, ".gz") || strings.HasSuffix(lower, ".tgz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		tmp, err := os.CreateTemp("", "spit-*.tar")
+		if err != nil {
+			return err
+		}
+		defer tmp.Close()
+		arc.data, arc.temp = tmp.Name(), true
+
+		cr := &countingReader{r: io.TeeReader(gz, tmp)}
+		r = cr
+		pos = func() (int64, error) {
+			return cr.n, nil
+		}
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		offset, err := pos()
+		if err != nil {
+			return err
+		}
+		fn(hdr, offset)
+	}
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// open returns the contents of the picture, whether it is stored
+// in an archive or not.
+func (p *picture) open() (io.ReadCloser, error) {
+	e := p.entry
+	if e == nil {
+		return os.Open(p.path)
+	}
+	if e.zip != nil {
+		return e.zip.Open()
+	}
+	f, err := os.Open(e.arc.data)
+	if err != nil {
+		return nil, err
+	}
+	return readCloser{io.NewSectionReader(f, e.offset, e.size), f.Close}, nil
+}
+
+// loadEntry resolves the metadata of pic, which is stored in an archive.
+func loadEntry(pic *picture, detect string, cache *metaCache) (*picture, error) {
+	rc, err := pic.open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return resolvePicture(rc, pic.name, pic.path, pic.size, pic.entry.arc.info, detect, cache)
+}
+
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (rc readCloser) Close() error { return rc.close() }
+
+// extractor provides previewers with real files for archive entries.
+// Only the most recently extracted file is kept around.
+type extractor struct {
+	dir  string
+	last string
+}
+
+// file returns a path the previewer can read pic from.
+func (e *extractor) file(pic *picture) (string, error) {
+	if pic.archive == "" {
+		return pic.path, nil
+	}
+	if e.dir == "" {
+		dir, err := os.MkdirTemp("", "spit-")
+		if err != nil {
+			return "", err
+		}
+		e.dir = dir
+	}
+	if e.last != "" {
+		os.Remove(e.last)
+		e.last = ""
+	}
+
+	rc, err := pic.open()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	// Keep the extension, since some previewers rely on it.
+	f, err := os.CreateTemp(e.dir, "*-"+path.Base(pic.name))
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(f, rc); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", fmt.Errorf("extracting %s: %w", pic.name, err)
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	e.last = f.Name()
+	return e.last, nil
+}
+
+// cleanup removes all extracted files.
+func (e *extractor) cleanup() error {
+	if e.dir == "" {
+		return nil
+	}
+	return os.RemoveAll(e.dir)
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"cmp"
+	"encoding/gob"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// cacheVersion is bumped whenever cacheEntry changes in an incompatible way.
+const cacheVersion = 3
+
+// usedInterval is how often the last use of an entry gets updated.
+// Updating it on every lookup would rewrite the cache on every launch.
+const usedInterval = 24 * time.Hour
+
+// maxCacheEntries caps the cache size. The least recently used entries
+// are dropped first.
+const maxCacheEntries = 100_000
+
+var defaultCachePath = cachePath()
+
+// cacheEntry holds the metadata of a single file.
+// It is only valid as long as size and modification time are unchanged.
+type cacheEntry struct {
+	Size          int64
+	ModTime       int64
+	Width, Height int
+	Format        string
+	Orientation   int
+	Taken         string
+	Used          int64
+}
+
+type cacheFile struct {
+	Version int
+	Entries map[string]cacheEntry
+}
+
+// metaCache is a persistent cache of picture metadata keyed by absolute path.
+// A nil *metaCache is valid and caches nothing.
+type metaCache struct {
+	path    string
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+	dirty   bool
+}
+
+// loadCache reads the cache at path. Missing or outdated caches
+// result in an empty cache rather than an error.
+func loadCache(path string) *metaCache {
+	c := &metaCache{path: path, entries: make(map[string]cacheEntry)}
+
+	f, err := os.Open(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			warnf("opening cache: %s", err)
+		}
+		return c
+	}
+	defer f.Close()
+
+	var cf cacheFile
+	if err := gob.NewDecoder(f).Decode(&cf); err != nil {
+		warnf("reading cache: %s", err)
+		return c
+	}
+	if cf.Version != cacheVersion {
+		infof("discarding cache version %d", cf.Version)
+		return c
+	}
+	if cf.Entries != nil {
+		c.entries = cf.Entries
+	}
+	debugf("loaded %d cache entries", len(c.entries))
+	return c
+}
+
+// lookup returns the cached metadata of the file at path,
+// if it is still up to date.
+func (c *metaCache) lookup(path string, info fs.FileInfo) (cacheEntry, bool) {
+	if c == nil {
+		return cacheEntry{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[path]
+	if !ok || e.Size != info.Size() || e.ModTime != info.ModTime().UnixNano() {
+		return cacheEntry{}, false
+	}
+	if now := time.Now().Unix(); now-e.Used >= int64(usedInterval/time.Second) {
+		e.Used = now
+		c.entries[path] = e
+		c.dirty = true
+	}
+	return e, true
+}
+
+// store adds or replaces the metadata of pic.
+func (c *metaCache) store(pic *picture, info fs.FileInfo) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[pic.path] = cacheEntry{
+		Size:        info.Size(),
+		ModTime:     info.ModTime().UnixNano(),
+		Width:       pic.width,
+		Height:      pic.height,
+		Format:      pic.format,
+		Orientation: pic.orientation,
+		Taken:       pic.taken,
+		Used:        time.Now().Unix(),
+	}
+	c.dirty = true
+}
+
+// save writes the cache back to disk if it changed.
+func (c *metaCache) save() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.dirty {
+		return nil
+	}
+	if n := len(c.entries) - maxCacheEntries; n > 0 {
+		keys := slices.SortedFunc(maps.Keys(c.entries), func(a, b string) int {
+			return cmp.Compare(c.entries[a].Used, c.entries[b].Used)
+		})
+		for _, k := range keys[:n] {
+			delete(c.entries, k)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o755); err != nil {
+		return err
+	}
+	// Write to a temporary file first, so concurrent instances never
+	// see a partially written cache.
+	f, err := os.CreateTemp(filepath.Dir(c.path), ".spit-cache-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	cf := cacheFile{Version: cacheVersion, Entries: c.entries}
+	if err := gob.NewEncoder(f).Encode(cf); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), c.path); err != nil {
+		return err
+	}
+	c.dirty = false
+	return nil
+}
+
+// cachePath returns the location of the metadata cache,
+// or "" if no location can be determined.
+func cachePath() string {
+	dir := cacheDir()
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, "spit", "meta.cache")
+}
+
+// cacheDir is like [os.UserCacheDir], but looks for $XDG_CACHE_HOME on all
+// platforms like [configDir]. It returns "" if no location can be determined.
+func cacheDir() string {
+	if dir := os.Getenv("XDG_CACHE_HOME"); dir != "" {
+		return dir
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return dir
+}
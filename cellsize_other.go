@@ -0,0 +1,9 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package main
+
+// cellSize returns the size of a terminal cell in pixels.
+// It returns 0, 0 if the terminal doesn't tell.
+func cellSize(fd int) (int, int) {
+	return 0, 0
+}
@@ -4,23 +4,43 @@ import (
 	"flag"
 	"fmt"
 	"path/filepath"
+	"slices"
 	"strconv"
+	"time"
 )
 
 var (
-	usageLine   = "usage: spit [-h] [-V] [-p] [-c FILE] [-n VALUE] [-log FILE] [path ...]"
+	usageLine   = "usage: spit [-h] [-V] [-p] [-print-desktop] [-desktop] [-c FILE] [-n VALUE] [-include PATTERN] [-exclude PATTERN] [-strict] [-nocache] [-watch] [-diff] [-slideshow DURATION] [-seed N] [-log FILE] [path ...]"
 	helpMessage = fmt.Sprintf(`
 spit - Show Pictures In Terminal
 
 positional arguments:
-  path          image files or directories (default: *)
+  path          image files, directories or archives (default: *)
 
 options:
   -h, -help     show this help message and exit
   -V, -version  show program's version number and exit
   -p            print default configuration and exit
+  -print-desktop
+                print a desktop entry registering spit as image viewer and exit
+  -desktop      open a terminal if necessary and load sibling images,
+                meant for desktop entries and xdg-open
   -c FILE       use this configuration file (default: %s)
   -n VALUE      set initial image using 1-based index or filename (default: 1)
+  -include PATTERN
+                only load paths matching PATTERN (repeatable)
+  -exclude PATTERN
+                skip paths matching PATTERN (repeatable)
+  -strict       exit with an error if any file could not be loaded,
+                or only check the files if stdin is not a terminal
+  -nocache      do not read or write the metadata cache
+  -watch        keep the image list in sync with the given directories,
+                or the current one if no paths are given,
+                waiting for new images while there are none
+  -diff         show the differences between the two given images
+  -slideshow DURATION
+                start a slideshow showing every image for DURATION (e.g. 5s)
+  -seed N       seed random orders and jumps with N, making them reproducible
   -log FILE     write debug information to FILE
 
 navigation:
@@ -28,8 +48,59 @@ navigation:
   l, j          [count] images forward
   g             go to first image
   G             go to image [count], default last image
+  d             cycle spread mode (none, ltr, rtl)
+  s             start, resume or stop the slideshow (any other key pauses it)
+  r             go to a random image not seen yet
+  i             show details and metadata of the image
+  p             show generation parameters and text chunks of the image
+  y             copy the generation prompt of the image
+  H             show histograms and color statistics of the image
+  t             enter gallery mode
+  m             mark or unmark image
+  c             compare marked images, default current and next image
+  D             show differences of two marked images, default current and next image
+  z             enter zoom mode
+  >, <          rotate clockwise or counterclockwise
+  |, _          flip horizontally or vertically
+  :             enter a command
+                  :skipped  list skipped files and why
+                  :save     save rotations and flips to the file
   ?             help
-  q             quit`,
+  q             quit
+
+animations (built-in renderers only):
+  space         pause or resume playback
+  ., ,          [count] frames forward or backward
+  ], [          double or halve playback speed
+
+zoom mode:
+  h, j, k, l    [count] pan left, down, up, right
+  H, J, K, L    [count] pan in larger steps
+  +, -          [count] zoom in or out
+  =             fit image to screen
+  w             fill screen
+  o             show original pixels (1:1)
+  [count]%%      zoom to count percent
+  z, q, Esc     leave zoom mode
+
+gallery mode:
+  h, j, k, l    [count] move left, down, up, right
+  b, f          [count] pages backward or forward
+  g, G          go to first image or image [count], default last image
+  m             mark or unmark image
+  Enter         show selected image
+  c             compare marked images
+  t, q, Esc     leave gallery mode
+
+compare mode (zoom mode keys apply to all images):
+  b             blink images in place, or show them side by side
+  space         pause or resume blinking
+  ., ,          [count] images forward or backward while blinking
+  D             show differences of the first two compared images
+  c, q, Esc     leave compare mode
+
+diff mode:
+  D, q, Esc     leave diff mode`,
 		defaultConfigPath)
 )
 
@@ -39,9 +110,21 @@ type flags struct {
 	help         bool
 	version      bool
 	printDefault bool
+	printDesktop bool
+	desktop      bool
 	startIdx     int
 	startPath    string
 	logPath      string
+	include      []string
+	exclude      []string
+	strict       bool
+	noCache      bool
+	watch        bool
+	diff         bool
+	slideshow    time.Duration
+	seed         uint64
+	hasSeed      bool
+	noArgs       bool
 	args         []string
 }
 
@@ -54,6 +137,12 @@ func parseFlags() flags {
 	flag.BoolVar(&cli.version, "V", false, "")
 	flag.BoolVar(&cli.version, "version", false, "")
 	flag.BoolVar(&cli.printDefault, "p", false, "")
+	flag.BoolVar(&cli.printDesktop, "print-desktop", false, "")
+	flag.BoolVar(&cli.desktop, "desktop", false, "")
+	flag.BoolVar(&cli.strict, "strict", false, "")
+	flag.BoolVar(&cli.noCache, "nocache", false, "")
+	flag.BoolVar(&cli.watch, "watch", false, "")
+	flag.BoolVar(&cli.diff, "diff", false, "")
 	flag.StringVar(&cli.logPath, "log", "", "")
 	flag.StringVar(&cli.configPath, "c", defaultConfigPath, "")
 	flag.Func("n", "", func(s string) error {
@@ -66,6 +155,33 @@ func parseFlags() flags {
 		cli.startPath = s
 		return nil
 	})
+	flag.Func("slideshow", "", func(s string) error {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		if d <= 0 {
+			return fmt.Errorf("must be > 0")
+		}
+		cli.slideshow = d
+		return nil
+	})
+	flag.Func("seed", "", func(s string) error {
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		cli.seed, cli.hasSeed = n, true
+		return nil
+	})
+	flag.Func("include", "", func(s string) error {
+		cli.include = append(cli.include, s)
+		return nil
+	})
+	flag.Func("exclude", "", func(s string) error {
+		cli.exclude = append(cli.exclude, s)
+		return nil
+	})
 	// When triggered by an error, print compact version to stderr.
 	flag.Usage = func() {
 		fmt.Fprintln(flag.CommandLine.Output(), usageLine)
@@ -74,20 +190,25 @@ func parseFlags() flags {
 
 	// Use images in cwd by default.
 	if cli.args = flag.Args(); len(cli.args) == 0 {
-		cli.args = []string{"*"}
+		cli.args, cli.noArgs = []string{"*"}, true
 	}
-	// On Windows, trusting the shell with wildcards is optimistic. We don't.
-	cli.args = expandGlobs(cli.args)
-
 	return cli
 }
 
 // expandGlobs expands wildcards in args using [filepath.Glob].
 // If an argument returns no matches, it is left unchanged.
-func expandGlobs(args []string) []string {
+// Like most shells, hidden files only match patterns starting with a dot,
+// unless hidden is set.
+func expandGlobs(args []string, hidden bool) []string {
 	out := make([]string, 0, len(args))
 	for _, pattern := range args {
-		if matches, _ := filepath.Glob(pattern); len(matches) > 0 {
+		matches, _ := filepath.Glob(pattern)
+		if !hidden && !isHidden(filepath.Base(pattern)) {
+			matches = slices.DeleteFunc(matches, func(m string) bool {
+				return isHidden(filepath.Base(m))
+			})
+		}
+		if len(matches) > 0 {
 			out = append(out, matches...)
 		} else {
 			out = append(out, pattern)
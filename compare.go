@@ -0,0 +1,269 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// maxCompared bounds the number of pictures compared at once.
+const maxCompared = 4
+
+// blinkInterval is how long each picture is shown while blinking.
+const blinkInterval = 500 * time.Millisecond
+
+// compare holds the state of compare mode, which shows several pictures
+// next to each other, zoomed and panned in sync.
+type compare struct {
+	pics []*picture
+	// zooms holds one view per picture, following the first one.
+	zooms []*zoom
+	// blink shows a single picture in place, alternating between them.
+	blink  bool
+	paused bool
+	// curr is the picture shown while blinking.
+	curr int
+	// due is when the next picture has to be shown while blinking.
+	due time.Time
+	// out caches the rendered panes until the view changes.
+	out   [][]byte
+	dirty bool
+}
+
+func newCompare(pics []*picture) *compare {
+	c := &compare{pics: pics, dirty: true}
+	for _, pic := range pics {
+		c.zooms = append(c.zooms, newZoom(pic))
+	}
+	c.invalidate()
+	return c
+}
+
+// invalidate makes the next draw render all panes again.
+func (c *compare) invalidate() {
+	c.out = make([][]byte, len(c.pics))
+	c.dirty = true
+	for _, z := range c.zooms[1:] {
+		z.mode, z.percent = c.zooms[0].mode, c.zooms[0].percent
+		z.cx, z.cy = c.zooms[0].cx, c.zooms[0].cy
+	}
+}
+
+// panes returns the indexes of the pictures on screen.
+func (c *compare) panes() []int {
+	if c.blink {
+		return []int{c.curr}
+	}
+	idx := make([]int, len(c.pics))
+	for i := range idx {
+		idx[i] = i
+	}
+	return idx
+}
+
+// toggleBlink switches between showing the pictures next to each other
+// and alternating them in place.
+func (c *compare) toggleBlink() {
+	c.blink = !c.blink
+	c.paused = false
+	c.due = time.Now().Add(blinkInterval)
+	c.invalidate()
+}
+
+// show switches to the picture delta pictures away while blinking.
+func (c *compare) show(delta int) {
+	c.curr = move(c.curr, len(c.pics), delta, true)
+	c.dirty = true
+}
+
+// advance shows the next picture once it is due.
+func (c *compare) advance() {
+	c.show(1)
+	// Don't try to catch up if we fell behind, e.g. while the help was shown.
+	c.due = c.due.Add(blinkInterval)
+	if now := time.Now(); now.After(c.due) {
+		c.due = now.Add(blinkInterval)
+	}
+}
+
+// status describes the view for the statusline.
+func (c *compare) status(a area) string {
+	s := c.zooms[0].status(a)
+	switch {
+	case c.blink && c.paused:
+		s += " blink paused"
+	case c.blink:
+		s += " blink"
+	}
+	return s
+}
+
+// comparedPictures returns the marked pictures, or the current one
+// along with the next if none are marked.
+func (v *viewer) comparedPictures() []*picture {
+	var pics []*picture
+	for _, pic := range v.pics {
+		if pic.marked {
+			pics = append(pics, pic)
+		}
+	}
+	if len(pics) == 0 && len(v.pics) > 1 {
+		next := move(v.curr, len(v.pics), 1, true)
+		pics = []*picture{v.pics[v.curr], v.pics[next]}
+	}
+	return pics
+}
+
+// openCompare switches to compare mode.
+func (v *viewer) openCompare() {
+	pics := v.comparedPictures()
+	switch {
+	case len(pics) < 2:
+		v.errMsg = "Mark at least two images to compare"
+		return
+	case len(pics) > maxCompared:
+		v.errMsg = fmt.Sprintf("Can't compare more than %d images", maxCompared)
+		return
+	}
+	v.compare = newCompare(pics)
+	v.zoom = nil
+	v.player = nil
+	v.shown = nil
+}
+
+// closeCompare switches back to the current picture.
+func (v *viewer) closeCompare() {
+	v.compare = nil
+	v.clearScreen()
+	v.shown = nil
+}
+
+// handleCompareKey executes the action bound to key in compare mode.
+// It reports whether key was handled.
+func (v *viewer) handleCompareKey(key rune, count int) bool {
+	c := v.compare
+	switch key {
+	case 'b':
+		c.toggleBlink()
+	case ' ':
+		if c.blink {
+			c.paused = !c.paused
+			c.due = time.Now().Add(blinkInterval)
+		}
+	case '.', ',':
+		if c.blink {
+			delta := max(count, 1)
+			if key == ',' {
+				delta = -delta
+			}
+			c.paused = true
+			c.show(delta)
+		}
+	case 'c', 'q', '\033':
+		v.closeCompare()
+	case 'D':
+		// Show the differences of the first two pictures compared.
+		v.closeCompare()
+		v.showDiff(c.pics[0], c.pics[1])
+	case '?', ':':
+		// Overlays clear the screen.
+		c.invalidate()
+		return false
+	default:
+		// The first picture leads, the others follow.
+		if c.zooms[0].handleKey(v.paneArea(c.panes()[0]), key, count) {
+			c.invalidate()
+		}
+	}
+	return true
+}
+
+// paneArea returns the part of the screen picture i is drawn into.
+// Two or three pictures are shown side by side, four in a grid.
+// Every pane ends with a row for its label.
+func (v *viewer) paneArea(i int) area {
+	c := v.compare
+	a := v.area(0, v.cols)
+	cols, rows := len(c.pics), 1
+	switch {
+	case c.blink:
+		cols, i = 1, 0
+	case len(c.pics) == 4:
+		cols, rows = 2, 2
+	}
+	w, h := a.cols/cols, a.rows/rows
+	a.x, a.y = (i%cols)*w, (i/cols)*h
+	a.cols, a.rows = w, max(h-1, 1)
+	return a
+}
+
+// drawCompare shows the compared pictures once they are loaded.
+func (v *viewer) drawCompare() error {
+	c := v.compare
+	loaded := !slices.ContainsFunc(c.pics, func(p *picture) bool {
+		return !p.loaded
+	})
+	if !loaded || !c.dirty {
+		if v.statusDirty {
+			v.drawStatus()
+		}
+		return nil
+	}
+	if slices.ContainsFunc(c.pics, func(p *picture) bool {
+		return p.width == 0 || p.height == 0
+	}) {
+		v.closeCompare()
+		v.errMsg = "Comparing requires known image dimensions"
+		return v.draw()
+	}
+
+	cols, rows, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil {
+		return err
+	}
+	if cols != v.cols || rows != v.rows {
+		v.cols, v.rows = cols, rows
+		c.invalidate()
+	}
+	c.dirty = false
+	v.errMsg = ""
+	v.clearScreen()
+	for _, i := range c.panes() {
+		pic, a := c.pics[i], v.paneArea(i)
+		if r := v.zoomRenderer(); r != nil {
+			if c.out[i] == nil {
+				out, err := v.drawZoomed(r, c.zooms[i], a)
+				if err != nil {
+					errorf("displaying image: %s", err)
+					v.errMsg = fmt.Sprintf("Error displaying %q", pic.path)
+				}
+				c.out[i] = out
+			}
+			os.Stdout.Write(c.out[i])
+		} else {
+			v.previewPane(pic, a, c.zooms[i])
+		}
+		label := fmt.Sprintf("%s  %dx%d", pic.name, pic.width, pic.height)
+		printAt(a.y+a.rows+1, a.x+1, truncateWidth(label, a.cols))
+	}
+	v.drawStatus()
+	return nil
+}
+
+// previewPane runs the previewer drawing pic into a, zoomed by z.
+func (v *viewer) previewPane(pic *picture, a area, z *zoom) {
+	path, err := v.extract.file(pic)
+	if err != nil {
+		errorf("extracting image: %s", err)
+		v.errMsg = fmt.Sprintf("Error extracting %q", pic.name)
+		return
+	}
+	moveCursor(a.y+1, a.x+1)
+	if err := execCmd(generateCmd(v.opt.previewer, v.preview(pic, path, a, z))); err != nil {
+		errorf("displaying image: %s", err)
+		v.errMsg = fmt.Sprintf("Error displaying %q", pic.path)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// desktopMimeTypes lists the types spit registers for in its desktop entry.
+var desktopMimeTypes = []string{
+	"image/bmp", "image/gif", "image/jpeg", "image/png", "image/tiff", "image/webp",
+	"application/vnd.comicbook+zip", "application/x-cbz", "application/x-cbt",
+}
+
+// desktopEntry returns a freedesktop.org desktop entry registering spit
+// as an image viewer.
+func desktopEntry() (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	b.WriteString("[Desktop Entry]\n")
+	b.WriteString("Type=Application\n")
+	b.WriteString("Name=spit\n")
+	b.WriteString("GenericName=Image Viewer\n")
+	b.WriteString("Comment=Show Pictures In Terminal\n")
+	// spit opens its own terminal, see reexecInTerminal.
+	fmt.Fprintf(&b, "Exec=%s -desktop %%f\n", desktopQuote(exe))
+	b.WriteString("Terminal=false\n")
+	b.WriteString("NoDisplay=true\n")
+	b.WriteString("Categories=Graphics;Viewer;\n")
+	fmt.Fprintf(&b, "MimeType=%s;", strings.Join(desktopMimeTypes, ";"))
+	return b.String(), nil
+}
+
+// desktopQuote quotes s for the Exec key if necessary.
+// Exec values are unescaped like any string value before the quoting
+// rules apply, so backslashes end up escaped twice. Percent signs
+// would start field codes.
+func desktopQuote(s string) string {
+	s = strings.ReplaceAll(s, "%", "%%")
+	if strings.ContainsAny(s, " \t\n\"'\\><~|&;$*?#()`") {
+		r := strings.NewReplacer(`"`, `\"`, "`", "\\`", "$", `\$`, `\`, `\\`)
+		s = `"` + r.Replace(s) + `"`
+	}
+	r := strings.NewReplacer(`\`, `\\`, "\n", `\n`, "\t", `\t`, "\r", `\r`)
+	return r.Replace(s)
+}
+
+// reexecEnv is set for spit instances started by reexecInTerminal.
+const reexecEnv = "SPIT_REEXEC"
+
+// reexecInTerminal runs spit again with the same arguments
+// inside the terminal emulator given by terminal.
+func reexecInTerminal(terminal string) error {
+	// Don't start terminals forever if the command doesn't provide one.
+	if os.Getenv(reexecEnv) != "" {
+		return errors.New("terminal emulator did not provide a terminal")
+	}
+	parts := strings.Fields(terminal)
+	if len(parts) == 0 {
+		return errors.New("not a terminal and no terminal emulator configured")
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	args := append(parts[1:], exe)
+	args = append(args, os.Args[1:]...)
+
+	infof("starting terminal: %s %s", parts[0], strings.Join(args, " "))
+	cmd := exec.Command(parts[0], args...)
+	cmd.Env = append(os.Environ(), reexecEnv+"=1")
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
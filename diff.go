@@ -0,0 +1,314 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"os"
+
+	"golang.org/x/term"
+)
+
+// ssimWindow is the size of the blocks SSIM is computed over.
+const ssimWindow = 8
+
+// imageDiff holds the differences between two images of the same size.
+type imageDiff struct {
+	// heatmap shows changed pixels in colors from red (small change)
+	// to white (largest change), over a dimmed copy of the first image.
+	heatmap        *image.RGBA
+	changed, total int
+	// maxDelta is the largest difference of any channel (0-255).
+	maxDelta int
+	// psnr is the peak signal-to-noise ratio in dB, +Inf if identical.
+	psnr float64
+	// ssim is the mean structural similarity of the luma, 1 if identical.
+	ssim float64
+}
+
+// diffImages compares img1 and img2 pixel by pixel.
+func diffImages(img1, img2 image.Image) (*imageDiff, error) {
+	b1, b2 := img1.Bounds(), img2.Bounds()
+	if b1.Size() != b2.Size() {
+		return nil, fmt.Errorf("images differ in size (%dx%d and %dx%d)", b1.Dx(), b1.Dy(), b2.Dx(), b2.Dy())
+	}
+	p1, p2 := toRGBA(img1), toRGBA(img2)
+	w, h := b1.Dx(), b1.Dy()
+
+	d := &imageDiff{total: w * h}
+	deltas := make([]uint8, w*h)
+	var sq float64
+	for i := range deltas {
+		o := i * 4
+		delta := 0
+		for c := range 4 {
+			v := absDiff(p1.Pix[o+c], p2.Pix[o+c])
+			delta = max(delta, v)
+			if c < 3 {
+				sq += float64(v * v)
+			}
+		}
+		deltas[i] = uint8(delta)
+		if delta > 0 {
+			d.changed++
+			d.maxDelta = max(d.maxDelta, delta)
+		}
+	}
+
+	d.psnr = math.Inf(1)
+	if mse := sq / float64(3*w*h); mse > 0 {
+		d.psnr = 10 * math.Log10(255*255/mse)
+	}
+	d.ssim = ssim(p1, p2)
+
+	d.heatmap = image.NewRGBA(image.Rect(0, 0, w, h))
+	for i, delta := range deltas {
+		o := i * 4
+		px := d.heatmap.Pix[o : o+4]
+		if delta == 0 {
+			y := luma(p1.Pix[o:o+3]) * 0.3
+			px[0], px[1], px[2] = uint8(y), uint8(y), uint8(y)
+		} else {
+			// Scale to the largest change, so small ones stand out as well.
+			r, g, b := heat(0.25 + 0.75*float64(delta)/float64(d.maxDelta))
+			px[0], px[1], px[2] = r, g, b
+		}
+		px[3] = 0xff
+	}
+	return d, nil
+}
+
+// summary describes the differences in a single line.
+func (d *imageDiff) summary() string {
+	if d.changed == 0 {
+		return "identical"
+	}
+	return fmt.Sprintf("changed %d of %d px (%.2f%%)  max delta %d  PSNR %.2f dB  SSIM %.4f",
+		d.changed, d.total, float64(d.changed)/float64(d.total)*100, d.maxDelta, d.psnr, d.ssim)
+}
+
+// ssim returns the mean structural similarity of the luma of p1 and p2,
+// computed over non-overlapping blocks.
+// See https://en.wikipedia.org/wiki/Structural_similarity_index_measure
+func ssim(p1, p2 *image.RGBA) float64 {
+	const (
+		c1 = (0.01 * 255) * (0.01 * 255)
+		c2 = (0.03 * 255) * (0.03 * 255)
+	)
+	w, h := p1.Rect.Dx(), p1.Rect.Dy()
+	var sum float64
+	blocks := 0
+	for by := 0; by < h; by += ssimWindow {
+		for bx := 0; bx < w; bx += ssimWindow {
+			var s1, s2, s11, s22, s12 float64
+			n := 0
+			for y := by; y < min(by+ssimWindow, h); y++ {
+				for x := bx; x < min(bx+ssimWindow, w); x++ {
+					o := y*p1.Stride + x*4
+					y1, y2 := luma(p1.Pix[o:o+3]), luma(p2.Pix[o:o+3])
+					s1 += y1
+					s2 += y2
+					s11 += y1 * y1
+					s22 += y2 * y2
+					s12 += y1 * y2
+					n++
+				}
+			}
+			fn := float64(n)
+			m1, m2 := s1/fn, s2/fn
+			v1, v2 := s11/fn-m1*m1, s22/fn-m2*m2
+			cov := s12/fn - m1*m2
+			sum += (2*m1*m2 + c1) * (2*cov + c2) / ((m1*m1 + m2*m2 + c1) * (v1 + v2 + c2))
+			blocks++
+		}
+	}
+	if blocks == 0 {
+		return 1
+	}
+	return sum / float64(blocks)
+}
+
+// luma returns the brightness of the RGB pixel p (Rec. 601).
+func luma(p []uint8) float64 {
+	return 0.299*float64(p[0]) + 0.587*float64(p[1]) + 0.114*float64(p[2])
+}
+
+// heat maps t (0-1) to black, red, yellow and white.
+func heat(t float64) (uint8, uint8, uint8) {
+	ch := func(v float64) uint8 {
+		return uint8(min(max(v, 0), 1) * 255)
+	}
+	return ch(3 * t), ch(3*t - 1), ch(3*t - 2)
+}
+
+func absDiff(a, b uint8) int {
+	if a > b {
+		return int(a - b)
+	}
+	return int(b - a)
+}
+
+// toRGBA returns img as *image.RGBA starting at the origin,
+// converting it if necessary.
+func toRGBA(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	if rgba, ok := img.(*image.RGBA); ok && b.Min == (image.Point{}) {
+		return rgba
+	}
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, b.Min, draw.Src)
+	return rgba
+}
+
+// diffResult carries a difference computed in the background.
+type diffResult struct {
+	a, b *picture
+	diff *imageDiff
+	err  error
+}
+
+// computeDiff compares the pictures a and b as displayed and sends
+// the result to results.
+func computeDiff(a, b *picture, results chan<- diffResult) {
+	img1, err := decodePicture(a)
+	if err != nil {
+		results <- diffResult{a: a, b: b, err: err}
+		return
+	}
+	img2, err := decodePicture(b)
+	if err != nil {
+		results <- diffResult{a: a, b: b, err: err}
+		return
+	}
+	d, err := diffImages(img1, img2)
+	results <- diffResult{a: a, b: b, diff: d, err: err}
+}
+
+// diffView holds the state of diff mode, which shows a heatmap of the
+// differences between two pictures.
+type diffView struct {
+	a, b *picture
+	// started is set once the pictures are loaded and being compared.
+	started bool
+	res     *diffResult
+	// out caches the rendered heatmap.
+	out   []byte
+	dirty bool
+}
+
+// openDiff switches to diff mode for the marked pictures,
+// or the current one and the next if none are marked.
+func (v *viewer) openDiff() {
+	pics := v.comparedPictures()
+	if len(pics) != 2 {
+		v.errMsg = "Mark two images to show their differences"
+		return
+	}
+	v.showDiff(pics[0], pics[1])
+}
+
+// showDiff switches to diff mode for a and b.
+func (v *viewer) showDiff(a, b *picture) {
+	v.diff = &diffView{a: a, b: b, dirty: true}
+	v.zoom = nil
+	v.player = nil
+	v.shown = nil
+}
+
+// closeDiff switches back to the current picture.
+func (v *viewer) closeDiff() {
+	v.diff = nil
+	v.clearScreen()
+	v.shown = nil
+}
+
+// handleDiff shows a difference once it is computed,
+// unless the user moved on in the meantime.
+func (v *viewer) handleDiff(res diffResult) {
+	d := v.diff
+	if d == nil || d.a != res.a || d.b != res.b {
+		return
+	}
+	if res.err != nil {
+		errorf("comparing %s and %s: %s", res.a.path, res.b.path, res.err)
+	}
+	d.res = &res
+	d.dirty = true
+}
+
+// handleDiffKey executes the action bound to key in diff mode.
+// It reports whether key was handled.
+func (v *viewer) handleDiffKey(key rune) bool {
+	switch key {
+	case 'D', 'q', '\033':
+		v.closeDiff()
+	case '?', ':':
+		// Overlays clear the screen.
+		v.diff.dirty = true
+		return false
+	}
+	return true
+}
+
+// diffRenderer returns the renderer used for heatmaps,
+// which falls back to blocks when using an external previewer.
+func (v *viewer) diffRenderer() renderer {
+	if v.render != nil {
+		return v.render
+	}
+	return blockRenderer{}
+}
+
+// drawDiff shows the heatmap along with a summary of the differences.
+func (v *viewer) drawDiff() error {
+	d := v.diff
+	if !d.started {
+		if !d.a.loaded || !d.b.loaded {
+			if v.statusDirty {
+				v.drawStatus()
+			}
+			return nil
+		}
+		d.started = true
+		go computeDiff(d.a, d.b, v.diffs)
+	}
+	if !d.dirty {
+		if v.statusDirty {
+			v.drawStatus()
+		}
+		return nil
+	}
+
+	cols, rows, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil {
+		return err
+	}
+	if cols != v.cols || rows != v.rows {
+		v.cols, v.rows = cols, rows
+		d.out = nil
+	}
+	d.dirty = false
+	v.errMsg = ""
+	v.clearScreen()
+
+	// The row above the statusline holds the summary.
+	a := v.area(0, v.cols)
+	a.rows = max(a.rows-1, 1)
+	summary := "Comparing " + d.a.name + " and " + d.b.name + "..."
+	switch {
+	case d.res == nil:
+	case d.res.err != nil:
+		summary = "Error: " + d.res.err.Error()
+	default:
+		if d.out == nil {
+			img := d.res.diff.heatmap
+			d.out = v.diffRenderer().encode(img, a, fitArea(img, a))
+		}
+		os.Stdout.Write(d.out)
+		summary = d.res.diff.summary()
+	}
+	printAt(a.rows+1, 1, truncateWidth(summary, v.cols))
+	v.drawStatus()
+	return nil
+}
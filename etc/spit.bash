@@ -9,9 +9,19 @@ _spit() {
 		-h -help
 		-V -version
 		-p
+		-print-desktop
+		-desktop
 		-c
 		-log
 		-n
+		-include
+		-exclude
+		-strict
+		-nocache
+		-watch
+		-diff
+		-slideshow
+		-seed
 	)
 
 	if [[ "$cur" == -* ]]; then
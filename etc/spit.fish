@@ -5,6 +5,16 @@
 complete -c spit -o h -o help -f -d 'show help message and exit'
 complete -c spit -o V -o version -f -d 'show program\'s version number and exit'
 complete -c spit -o p -f -d 'print default configuration and exit'
+complete -c spit -o print-desktop -f -d 'print a desktop entry and exit'
+complete -c spit -o desktop -d 'open a terminal if necessary and load sibling images'
 complete -c spit -o c -r -d 'use this configuration file'
 complete -c spit -o log -r -d 'write debug information to this file'
 complete -c spit -o n -x -d 'set initial image using 1-based index or filename'
+complete -c spit -o include -x -d 'only load paths matching this pattern'
+complete -c spit -o exclude -x -d 'skip paths matching this pattern'
+complete -c spit -o strict -f -d 'exit with an error if any file could not be loaded'
+complete -c spit -o nocache -f -d 'do not read or write the metadata cache'
+complete -c spit -o watch -f -d 'keep the image list in sync with the given directories'
+complete -c spit -o diff -f -d 'show the differences between the two given images'
+complete -c spit -o slideshow -x -d 'start a slideshow showing every image for this duration'
+complete -c spit -o seed -x -d 'seed random orders and jumps, making them reproducible'
@@ -10,14 +10,24 @@ Register-ArgumentCompleter -Native -CommandName 'spit' -ScriptBlock {
 	$null = $commandAst, $cursorPosition
 
 	$completions = @(
-		[CompletionResult]::new('-h',       '-h',       [CompletionResultType]::ParameterName, 'show help message and exit')
-		[CompletionResult]::new('-help',    '-help',    [CompletionResultType]::ParameterName, 'show help message and exit')
-		[CompletionResult]::new('-V',       '-V',       [CompletionResultType]::ParameterName, "show program's version number and exit")
-		[CompletionResult]::new('-version', '-version', [CompletionResultType]::ParameterName, "show program's version number and exit")
-		[CompletionResult]::new('-p',       '-p',       [CompletionResultType]::ParameterName, 'print default configuration and exit')
-		[CompletionResult]::new('-c ',      '-c',       [CompletionResultType]::ParameterName, 'use this configuration file')
-		[CompletionResult]::new('-log ',    '-log',     [CompletionResultType]::ParameterName, 'write debug information to FILE')
-		[CompletionResult]::new('-n ',      '-n',       [CompletionResultType]::ParameterName, 'set initial image using 1-based index or filename')
+		[CompletionResult]::new('-h',             '-h',             [CompletionResultType]::ParameterName, 'show help message and exit')
+		[CompletionResult]::new('-help',          '-help',          [CompletionResultType]::ParameterName, 'show help message and exit')
+		[CompletionResult]::new('-V',             '-V',             [CompletionResultType]::ParameterName, "show program's version number and exit")
+		[CompletionResult]::new('-version',       '-version',       [CompletionResultType]::ParameterName, "show program's version number and exit")
+		[CompletionResult]::new('-p',             '-p',             [CompletionResultType]::ParameterName, 'print default configuration and exit')
+		[CompletionResult]::new('-print-desktop', '-print-desktop', [CompletionResultType]::ParameterName, 'print a desktop entry and exit')
+		[CompletionResult]::new('-desktop',       '-desktop',       [CompletionResultType]::ParameterName, 'open a terminal if necessary and load sibling images')
+		[CompletionResult]::new('-c ',            '-c',             [CompletionResultType]::ParameterName, 'use this configuration file')
+		[CompletionResult]::new('-log ',          '-log',           [CompletionResultType]::ParameterName, 'write debug information to FILE')
+		[CompletionResult]::new('-n ',            '-n',             [CompletionResultType]::ParameterName, 'set initial image using 1-based index or filename')
+		[CompletionResult]::new('-include ',      '-include',       [CompletionResultType]::ParameterName, 'only load paths matching PATTERN')
+		[CompletionResult]::new('-exclude ',      '-exclude',       [CompletionResultType]::ParameterName, 'skip paths matching PATTERN')
+		[CompletionResult]::new('-strict',        '-strict',        [CompletionResultType]::ParameterName, 'exit with an error if any file could not be loaded')
+		[CompletionResult]::new('-nocache',       '-nocache',       [CompletionResultType]::ParameterName, 'do not read or write the metadata cache')
+		[CompletionResult]::new('-watch',         '-watch',         [CompletionResultType]::ParameterName, 'keep the image list in sync with the given directories')
+		[CompletionResult]::new('-diff',          '-diff',          [CompletionResultType]::ParameterName, 'show the differences between the two given images')
+		[CompletionResult]::new('-slideshow ',    '-slideshow',     [CompletionResultType]::ParameterName, 'start a slideshow showing every image for DURATION')
+		[CompletionResult]::new('-seed ',         '-seed',          [CompletionResultType]::ParameterName, 'seed random orders and jumps with N')
 	)
 
 	if ($wordToComplete.StartsWith('-')) {
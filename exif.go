@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// readExif returns the Exif block (a TIFF structure) of the image read
+// from r, or nil if it has none. r has to be positioned at the start of the file.
+func readExif(r io.Reader, format string) []byte {
+	switch format {
+	case "jpeg":
+		return jpegExif(r)
+	case "png":
+		return pngExif(r)
+	case "webp":
+		return webpExif(r)
+	case "tiff":
+		// The file itself is a TIFF structure.
+		return tiffHead(r)
+	}
+	return nil
+}
+
+// jpegExif looks for an APP1 Exif segment before the image data.
+func jpegExif(r io.Reader) []byte {
+	var exif []byte
+	jpegSegments(r, func(marker byte, seg []byte) bool {
+		if tiff, ok := bytes.CutPrefix(seg, []byte("Exif\x00\x00")); ok && marker == 0xe1 {
+			exif = tiff
+			return false
+		}
+		return true
+	})
+	return exif
+}
+
+// pngExif looks for an eXIf chunk before the image data.
+func pngExif(r io.Reader) []byte {
+	var exif []byte
+	pngChunks(r, func(typ string, data []byte) bool {
+		switch typ {
+		case "IDAT", "IEND":
+			return false
+		case "eXIf":
+			exif = data
+			return false
+		}
+		return true
+	})
+	return exif
+}
+
+// webpExif looks for an EXIF chunk, which usually comes last.
+func webpExif(r io.Reader) []byte {
+	var exif []byte
+	extended := false
+	webpChunks(r, func(fourcc string, data []byte) bool {
+		switch fourcc {
+		case "VP8X":
+			// Don't read through the whole file unless there is an EXIF chunk.
+			extended = true
+			return len(data) > 0 && data[0]&0x08 != 0
+		case "VP8 ", "VP8L":
+			// Simple files can't hold metadata.
+			return extended
+		case "EXIF":
+			// Some writers keep the JPEG style prefix.
+			exif, _ = bytes.CutPrefix(data, []byte("Exif\x00\x00"))
+			return false
+		}
+		return true
+	})
+	return exif
+}
+
+// Exif value types, besides typeShort.
+const (
+	typeByte      = 1
+	typeASCII     = 2
+	typeLong      = 4
+	typeRational  = 5
+	typeUndefined = 7
+	typeSLong     = 9
+	typeSRational = 10
+)
+
+// typeSizes holds the size of a single value of each type in bytes.
+var typeSizes = map[uint16]int{
+	typeByte:      1,
+	typeASCII:     1,
+	typeShort:     2,
+	typeLong:      4,
+	typeRational:  8,
+	typeUndefined: 1,
+	typeSLong:     4,
+	typeSRational: 8,
+}
+
+// Tags pointing to the Exif and GPS IFDs.
+const (
+	tagExifIFD = 0x8769
+	tagGPSIFD  = 0x8825
+)
+
+// exifTags names the tags of IFD0 and the Exif IFD worth showing.
+// Names follow ExifTool where they differ from the specification.
+var exifTags = map[uint16]string{
+	0x010e: "ImageDescription",
+	0x010f: "Make",
+	0x0110: "Model",
+	0x0112: "Orientation",
+	0x0131: "Software",
+	0x0132: "ModifyDate",
+	0x013b: "Artist",
+	0x8298: "Copyright",
+	0x829a: "ExposureTime",
+	0x829d: "FNumber",
+	0x8822: "ExposureProgram",
+	0x8827: "ISO",
+	0x9003: "DateTimeOriginal",
+	0x9004: "CreateDate",
+	0x9010: "OffsetTime",
+	0x9204: "ExposureCompensation",
+	0x9207: "MeteringMode",
+	0x9209: "Flash",
+	0x920a: "FocalLength",
+	0xa002: "ExifImageWidth",
+	0xa003: "ExifImageHeight",
+	0xa402: "ExposureMode",
+	0xa403: "WhiteBalance",
+	0xa405: "FocalLengthIn35mmFormat",
+	0xa430: "OwnerName",
+	0xa431: "SerialNumber",
+	0xa433: "LensMake",
+	0xa434: "LensModel",
+}
+
+// gpsTags names the tags of the GPS IFD.
+var gpsTags = map[uint16]string{
+	0x0001: "GPSLatitudeRef",
+	0x0002: "GPSLatitude",
+	0x0003: "GPSLongitudeRef",
+	0x0004: "GPSLongitude",
+	0x0005: "GPSAltitudeRef",
+	0x0006: "GPSAltitude",
+}
+
+// maxExifValues bounds the number of values of a single tag.
+const maxExifValues = 256
+
+// parseExif returns the known tags of the Exif block b by name,
+// formatted for display. GPS coordinates are in decimal degrees.
+func parseExif(b []byte) map[string]string {
+	fields := make(map[string]string)
+	bo := tiffByteOrder(b)
+	if bo == nil {
+		return fields
+	}
+
+	ptrs := readIFD(b, bo, bo.Uint32(b[4:]), exifTags, fields)
+	if off, ok := ptrs[tagExifIFD]; ok {
+		readIFD(b, bo, off, exifTags, fields)
+	}
+	if off, ok := ptrs[tagGPSIFD]; ok {
+		gps := make(map[string]string)
+		readIFD(b, bo, off, gpsTags, gps)
+		addGPS(fields, gps)
+	}
+	return fields
+}
+
+// readIFD adds the tags of the IFD at off listed in names to fields.
+// It returns the offsets of the sub-IFDs found.
+func readIFD(b []byte, bo binary.ByteOrder, off uint32, names map[uint16]string, fields map[string]string) map[uint16]uint32 {
+	ptrs := make(map[uint16]uint32)
+	if uint64(off)+2 > uint64(len(b)) {
+		return ptrs
+	}
+	n := uint64(bo.Uint16(b[off:]))
+	for i := range n {
+		e := uint64(off) + 2 + 12*i
+		if e+12 > uint64(len(b)) {
+			break
+		}
+		tag, typ, count := bo.Uint16(b[e:]), bo.Uint16(b[e+2:]), bo.Uint32(b[e+4:])
+		if tag == tagExifIFD || tag == tagGPSIFD {
+			ptrs[tag] = bo.Uint32(b[e+8:])
+			continue
+		}
+		name, ok := names[tag]
+		size, known := typeSizes[typ]
+		if !ok || !known || count == 0 || (count > maxExifValues && typ != typeASCII) {
+			continue
+		}
+		end := uint64(size) * uint64(count)
+		data := b[e+8 : e+12]
+		if end > 4 {
+			pos := uint64(bo.Uint32(b[e+8:]))
+			if pos+end > uint64(len(b)) {
+				continue
+			}
+			data = b[pos : pos+end]
+		}
+		if s := formatExifValue(bo, typ, data[:end]); s != "" {
+			fields[name] = s
+		}
+	}
+	return ptrs
+}
+
+// formatExifValue returns the values of type typ in data as text.
+func formatExifValue(bo binary.ByteOrder, typ uint16, data []byte) string {
+	var vals []string
+	switch typ {
+	case typeASCII, typeUndefined:
+		s := strings.TrimSpace(strings.TrimRight(string(data), "\x00"))
+		if !utf8.ValidString(s) || strings.ContainsFunc(s, func(r rune) bool { return !unicode.IsPrint(r) }) {
+			return ""
+		}
+		return s
+	case typeByte:
+		for _, v := range data {
+			vals = append(vals, strconv.Itoa(int(v)))
+		}
+	case typeShort:
+		for i := 0; i < len(data); i += 2 {
+			vals = append(vals, strconv.Itoa(int(bo.Uint16(data[i:]))))
+		}
+	case typeLong:
+		for i := 0; i < len(data); i += 4 {
+			vals = append(vals, strconv.FormatUint(uint64(bo.Uint32(data[i:])), 10))
+		}
+	case typeSLong:
+		for i := 0; i < len(data); i += 4 {
+			vals = append(vals, strconv.Itoa(int(int32(bo.Uint32(data[i:])))))
+		}
+	case typeRational, typeSRational:
+		for i := 0; i < len(data); i += 8 {
+			n, d := int64(bo.Uint32(data[i:])), int64(bo.Uint32(data[i+4:]))
+			if typ == typeSRational {
+				n, d = int64(int32(n)), int64(int32(d))
+			}
+			vals = append(vals, formatRational(n, d))
+		}
+	}
+	return strings.Join(vals, " ")
+}
+
+// formatRational returns n/d as a fraction if it is one over something,
+// like exposure times, or as a decimal number otherwise.
+func formatRational(n, d int64) string {
+	if d == 0 {
+		return "0"
+	}
+	if g := gcd(n, d); g > 1 {
+		n, d = n/g, d/g
+	}
+	switch {
+	case d == 1:
+		return strconv.FormatInt(n, 10)
+	case n == 1:
+		return "1/" + strconv.FormatInt(d, 10)
+	}
+	return strconv.FormatFloat(math.Round(float64(n)/float64(d)*1e4)/1e4, 'f', -1, 64)
+}
+
+func gcd(a, b int64) int64 {
+	a, b = max(a, -a), max(b, -b)
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// addGPS adds the position from the GPS tags in gps to fields.
+func addGPS(fields, gps map[string]string) {
+	for _, c := range []struct{ name, neg string }{
+		{"GPSLatitude", "S"},
+		{"GPSLongitude", "W"},
+	} {
+		// Degrees, minutes and seconds.
+		parts := strings.Fields(gps[c.name])
+		if len(parts) != 3 {
+			continue
+		}
+		deg := 0.0
+		for i, p := range parts {
+			v, err := strconv.ParseFloat(p, 64)
+			if err != nil {
+				// Fractions only show up for values below 1.
+				v = parseFraction(p)
+			}
+			deg += v / math.Pow(60, float64(i))
+		}
+		if gps[c.name+"Ref"] == c.neg {
+			deg = -deg
+		}
+		fields[c.name] = strconv.FormatFloat(deg, 'f', 6, 64)
+	}
+	if alt, ok := gps["GPSAltitude"]; ok {
+		v, err := strconv.ParseFloat(alt, 64)
+		if err != nil {
+			v = parseFraction(alt)
+		}
+		if gps["GPSAltitudeRef"] == "1" { // below sea level
+			v = -v
+		}
+		fields["GPSAltitude"] = strconv.FormatFloat(v, 'f', -1, 64)
+	}
+}
+
+// parseFraction parses fractions like "1/4" as returned by formatRational.
+func parseFraction(s string) float64 {
+	n, d, ok := strings.Cut(s, "/")
+	if !ok {
+		return 0
+	}
+	nv, err1 := strconv.ParseFloat(n, 64)
+	dv, err2 := strconv.ParseFloat(d, 64)
+	if err1 != nil || err2 != nil || dv == 0 {
+		return 0
+	}
+	return nv / dv
+}
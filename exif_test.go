@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"maps"
+	"testing"
+)
+
+type testEntry struct {
+	tag, typ uint16
+	count    uint32
+	data     []byte
+}
+
+// rationals encodes pairs of numerators and denominators.
+func rationals(bo binary.AppendByteOrder, v ...uint32) []byte {
+	var b []byte
+	for _, x := range v {
+		b = bo.AppendUint32(b, x)
+	}
+	return b
+}
+
+// buildIFD lays out an IFD at off, followed by the values too large
+// to fit into their entries.
+func buildIFD(bo binary.AppendByteOrder, off uint32, entries []testEntry) []byte {
+	extra := off + 2 + 12*uint32(len(entries)) + 4
+	var b, values []byte
+	b = bo.AppendUint16(b, uint16(len(entries)))
+	for _, e := range entries {
+		b = bo.AppendUint16(b, e.tag)
+		b = bo.AppendUint16(b, e.typ)
+		b = bo.AppendUint32(b, e.count)
+		if len(e.data) <= 4 {
+			b = append(b, e.data...)
+			b = append(b, make([]byte, 4-len(e.data))...)
+			continue
+		}
+		b = bo.AppendUint32(b, extra+uint32(len(values)))
+		values = append(values, e.data...)
+	}
+	b = bo.AppendUint32(b, 0) // no next IFD
+	return append(b, values...)
+}
+
+// buildExif returns a TIFF structure with IFD0 followed by the
+// Exif and GPS IFDs, which are left out if empty.
+func buildExif(order string, ifd0, exif, gps []testEntry) []byte {
+	var bo binary.AppendByteOrder = binary.LittleEndian
+	if order == "MM" {
+		bo = binary.BigEndian
+	}
+	// The size of IFD0 doesn't depend on the offsets it points to.
+	ptrs := func(exifOff, gpsOff uint32) []testEntry {
+		entries := ifd0
+		if len(exif) > 0 {
+			entries = append(entries, testEntry{tagExifIFD, typeLong, 1, bo.AppendUint32(nil, exifOff)})
+		}
+		if len(gps) > 0 {
+			entries = append(entries, testEntry{tagGPSIFD, typeLong, 1, bo.AppendUint32(nil, gpsOff)})
+		}
+		return entries
+	}
+	exifOff := 8 + uint32(len(buildIFD(bo, 8, ptrs(0, 0))))
+	exifIFD := buildIFD(bo, exifOff, exif)
+	gpsOff := exifOff
+	if len(exif) > 0 {
+		gpsOff += uint32(len(exifIFD))
+	}
+
+	b := []byte(order)
+	b = bo.AppendUint16(b, 42)
+	b = bo.AppendUint32(b, 8)
+	b = append(b, buildIFD(bo, 8, ptrs(exifOff, gpsOff))...)
+	if len(exif) > 0 {
+		b = append(b, exifIFD...)
+	}
+	if len(gps) > 0 {
+		b = append(b, buildIFD(bo, gpsOff, gps)...)
+	}
+	return b
+}
+
+func TestParseExif(t *testing.T) {
+	le, be := binary.LittleEndian, binary.BigEndian
+	tests := []struct {
+		name string
+		b    []byte
+		want map[string]string
+	}{
+		{
+			name: "camera",
+			b: buildExif("II", []testEntry{
+				{0x010f, typeASCII, 6, []byte("Canon\x00")},
+				{0x0110, typeASCII, 13, []byte("Canon EOS R6\x00")},
+				{0x0112, typeShort, 1, le.AppendUint16(nil, 6)},
+			}, []testEntry{
+				{0x829a, typeRational, 1, rationals(le, 1, 250)},
+				{0x829d, typeRational, 1, rationals(le, 28, 10)},
+				{0x8827, typeShort, 1, le.AppendUint16(nil, 400)},
+				{0x9204, typeSRational, 1, rationals(le, 0xfffffffd, 3)},
+				{0x9003, typeASCII, 20, []byte("2024:05:01 12:30:00\x00")},
+			}, nil),
+			want: map[string]string{
+				"Make":                 "Canon",
+				"Model":                "Canon EOS R6",
+				"Orientation":          "6",
+				"ExposureTime":         "1/250",
+				"FNumber":              "2.8",
+				"ISO":                  "400",
+				"ExposureCompensation": "-1",
+				"DateTimeOriginal":     "2024:05:01 12:30:00",
+			},
+		},
+		{
+			name: "GPS",
+			b: buildExif("MM", nil, nil, []testEntry{
+				{0x0001, typeASCII, 2, []byte("S\x00")},
+				{0x0002, typeRational, 3, rationals(be, 33, 1, 51, 1, 54, 1)},
+				{0x0003, typeASCII, 2, []byte("E\x00")},
+				{0x0004, typeRational, 3, rationals(be, 151, 1, 12, 1, 36, 1)},
+				{0x0005, typeByte, 1, []byte{1}},
+				{0x0006, typeRational, 1, rationals(be, 5, 2)},
+			}),
+			want: map[string]string{
+				"GPSLatitude":  "-33.865000",
+				"GPSLongitude": "151.210000",
+				"GPSAltitude":  "-2.5",
+			},
+		},
+		{
+			name: "unknown and invalid",
+			b: buildExif("II", []testEntry{
+				{0x0100, typeShort, 1, le.AppendUint16(nil, 640)},                       // not shown
+				{0x010e, typeASCII, 4, []byte("\x1b[2")},                                // control characters
+				{0x0131, 99, 1, []byte{1}},                                              // unknown type
+				{0x013b, typeASCII, 1000, []byte("out of bounds")},                      // past the end
+				{0x8298, typeASCII, 0, nil},                                             // no values
+				{0x010f, typeShort, maxExifValues + 1, make([]byte, 2*maxExifValues+2)}, // too many values
+			}, nil, nil),
+			want: map[string]string{},
+		},
+		{"truncated", buildExif("II", []testEntry{{0x010f, typeASCII, 6, []byte("Canon\x00")}}, nil, nil)[:12], map[string]string{}},
+		{"no TIFF", []byte("not exif data"), map[string]string{}},
+		{"empty", nil, map[string]string{}},
+	}
+	for _, tt := range tests {
+		if got := parseExif(tt.b); !maps.Equal(got, tt.want) {
+			t.Errorf("%s: parseExif = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFormatRational(t *testing.T) {
+	tests := []struct {
+		n, d int64
+		want string
+	}{
+		{1, 250, "1/250"},
+		{10, 2500, "1/250"},
+		{28, 10, "2.8"},
+		{50, 1, "50"},
+		{2, 3, "0.6667"},
+		{-1, 3, "-0.3333"},
+		{0, 1, "0"},
+		{5, 0, "0"},
+	}
+	for _, tt := range tests {
+		if got := formatRational(tt.n, tt.d); got != tt.want {
+			t.Errorf("formatRational(%d, %d) = %q, want %q", tt.n, tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestReadExif(t *testing.T) {
+	exif := newExif(orientRotate90)
+
+	var jpeg bytes.Buffer
+	jpeg.WriteString("\xff\xd8")
+	jpeg.WriteString("\xff\xe0\x00\x04\x00\x00") // APP0
+	jpeg.WriteString("\xff\xe1")
+	binary.Write(&jpeg, binary.BigEndian, uint16(2+6+len(exif)))
+	jpeg.WriteString("Exif\x00\x00")
+	jpeg.Write(exif)
+	jpeg.WriteString("\xff\xda\x00\x02")
+
+	var png bytes.Buffer
+	png.WriteString("\x89PNG\r\n\x1a\n")
+	writeChunk(&png, "IHDR", make([]byte, 13))
+	writeChunk(&png, "eXIf", exif)
+	writeChunk(&png, "IDAT", nil)
+	writeChunk(&png, "IEND", nil)
+
+	var late bytes.Buffer
+	late.WriteString("\x89PNG\r\n\x1a\n")
+	writeChunk(&late, "IHDR", make([]byte, 13))
+	writeChunk(&late, "IDAT", nil)
+	writeChunk(&late, "eXIf", exif)
+	writeChunk(&late, "IEND", nil)
+
+	webp := func(flags byte) []byte {
+		var body bytes.Buffer
+		body.WriteString("WEBP")
+		writeRIFFChunk(&body, "VP8X", append([]byte{flags}, make([]byte, 9)...))
+		writeRIFFChunk(&body, "VP8 ", make([]byte, 3))
+		writeRIFFChunk(&body, "EXIF", exif)
+		riff := binary.LittleEndian.AppendUint32([]byte("RIFF"), uint32(body.Len()))
+		return append(riff, body.Bytes()...)
+	}
+
+	tests := []struct {
+		format string
+		data   []byte
+		want   int
+	}{
+		{"jpeg", jpeg.Bytes(), orientRotate90},
+		{"png", png.Bytes(), orientRotate90},
+		// eXIf has to come before the image data.
+		{"png", late.Bytes(), 0},
+		{"webp", webp(0x08), orientRotate90},
+		// The EXIF chunk is ignored unless the header announces it.
+		{"webp", webp(0), 0},
+		{"tiff", exif, orientRotate90},
+		{"gif", []byte("GIF89a"), 0},
+	}
+	for _, tt := range tests {
+		b := readExif(bytes.NewReader(tt.data), tt.format)
+		if got := tiffOrientation(b); got != tt.want {
+			t.Errorf("%s: orientation = %d, want %d", tt.format, got, tt.want)
+		}
+	}
+}
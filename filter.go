@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// globRule is a single gitignore-style pattern.
+type globRule struct {
+	segs     []string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+// parseRule parses a gitignore-style pattern.
+// It reports false for blank lines and comments.
+func parseRule(s string) (globRule, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" || strings.HasPrefix(s, "#") {
+		return globRule{}, false
+	}
+	var r globRule
+	if strings.HasPrefix(s, "!") {
+		r.negate = true
+		s = s[1:]
+	}
+	if strings.HasSuffix(s, "/") {
+		r.dirOnly = true
+		s = strings.TrimRight(s, "/")
+	}
+	// Patterns containing a slash are relative to their base,
+	// everything else matches at any level.
+	r.anchored = strings.Contains(s, "/")
+	s = strings.TrimPrefix(s, "/")
+	if s == "" {
+		return globRule{}, false
+	}
+	r.segs = strings.Split(s, "/")
+	return r, true
+}
+
+// match reports whether rel, a slash separated path, is matched by r.
+// Like git, a match on any parent directory also matches its contents.
+func (r globRule) match(rel string) bool {
+	parts := strings.Split(rel, "/")
+	n := len(parts)
+	if r.dirOnly {
+		n--
+	}
+	for end := n; end >= 1; end-- {
+		if r.anchored {
+			if matchSegs(r.segs, parts[:end]) {
+				return true
+			}
+			continue
+		}
+		for start := range end {
+			if matchSegs(r.segs, parts[start:end]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchSegs matches path segments against pattern segments,
+// where "**" matches zero or more segments.
+func matchSegs(pattern, parts []string) bool {
+	if len(pattern) == 0 {
+		return len(parts) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(parts); i++ {
+			if matchSegs(pattern[1:], parts[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(parts) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pattern[0], parts[0]); !ok {
+		return false
+	}
+	return matchSegs(pattern[1:], parts[1:])
+}
+
+// globRules is an ordered list of rules where the last match wins.
+type globRules []globRule
+
+func parseRules(patterns []string) globRules {
+	var rules globRules
+	for _, p := range patterns {
+		if r, ok := parseRule(p); ok {
+			rules = append(rules, r)
+		}
+	}
+	return rules
+}
+
+// readRules parses an ignore file. A missing file yields no rules.
+func readRules(name string) globRules {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var rules globRules
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if r, ok := parseRule(s.Text()); ok {
+			rules = append(rules, r)
+		}
+	}
+	return rules
+}
+
+// matched reports whether rel is matched by rules.
+func (rules globRules) matched(rel string) bool {
+	ok := false
+	for _, r := range rules {
+		if r.match(rel) {
+			ok = !r.negate
+		}
+	}
+	return ok
+}
+
+// pathFilter decides which input paths are considered.
+type pathFilter struct {
+	extensions  []string
+	include     globRules
+	exclude     globRules
+	hidden      bool
+	ignoreFiles []string
+}
+
+func newPathFilter(opt options, cli flags) *pathFilter {
+	pf := &pathFilter{
+		include:     parseRules(append(slices.Clone(opt.include), cli.include...)),
+		exclude:     parseRules(append(slices.Clone(opt.exclude), cli.exclude...)),
+		hidden:      opt.hidden,
+		ignoreFiles: opt.ignorefiles,
+	}
+	if opt.detect != "content" {
+		pf.extensions = opt.extensions
+	}
+	return pf
+}
+
+// skipReason reports why the file at p should not be loaded.
+// It returns "" if the file should be kept.
+func (pf *pathFilter) skipReason(p string) string {
+	if len(pf.extensions) > 0 &&
+		!slices.Contains(pf.extensions, strings.ToLower(filepath.Ext(p))) {
+		return "extension filtered"
+	}
+	// Anchored patterns are relative to the working directory
+	// or the root for absolute paths.
+	rel := strings.TrimLeft(filepath.ToSlash(filepath.Clean(p)), "/")
+	if len(pf.include) > 0 && !pf.include.matched(rel) {
+		return "not included"
+	}
+	if pf.exclude.matched(rel) {
+		return "excluded"
+	}
+	return ""
+}
+
+// entryReason is like skipReason, but only covers the checks specific
+// to files found while expanding a directory.
+func (pf *pathFilter) entryReason(name string, ignored globRules) string {
+	switch {
+	case !pf.hidden && isHidden(name):
+		return "hidden"
+	case ignored.matched(name):
+		return "ignored"
+	}
+	return ""
+}
+
+// dirRules returns the rules of all ignore files found in dir.
+func (pf *pathFilter) dirRules(dir string) globRules {
+	var rules globRules
+	for _, name := range pf.ignoreFiles {
+		rules = append(rules, readRules(filepath.Join(dir, name))...)
+	}
+	return rules
+}
+
+func isHidden(name string) bool {
+	return strings.HasPrefix(name, ".") && name != "." && name != ".."
+}
@@ -0,0 +1,78 @@
+package main
+
+import "testing"
+
+func TestGlobRulesMatched(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		rel      string
+		want     bool
+	}{
+		{[]string{"*.png"}, "a.png", true},
+		{[]string{"*.png"}, "dir/sub/a.png", true},
+		{[]string{"*.png"}, "a.jpg", false},
+		{[]string{"@eaDir"}, "photos/@eaDir/a.jpg", true},
+		{[]string{"/top.jpg"}, "top.jpg", true},
+		{[]string{"/top.jpg"}, "dir/top.jpg", false},
+		{[]string{"dir/*.jpg"}, "dir/a.jpg", true},
+		{[]string{"dir/*.jpg"}, "other/dir/a.jpg", false},
+		{[]string{"raw/"}, "raw/a.jpg", true},
+		{[]string{"raw/"}, "raw", false},
+		{[]string{"a/**/b.jpg"}, "a/b.jpg", true},
+		{[]string{"a/**/b.jpg"}, "a/x/y/b.jpg", true},
+		{[]string{"**/tmp"}, "x/tmp/a.jpg", true},
+		{[]string{"*.jpg", "!keep.jpg"}, "keep.jpg", false},
+		{[]string{"*.jpg", "!keep.jpg"}, "drop.jpg", true},
+		{[]string{"!keep.jpg", "*.jpg"}, "keep.jpg", true},
+		{[]string{"# comment", "", "  "}, "a.jpg", false},
+	}
+	for _, tt := range tests {
+		if got := parseRules(tt.patterns).matched(tt.rel); got != tt.want {
+			t.Errorf("%q matched %q = %v, want %v", tt.patterns, tt.rel, got, tt.want)
+		}
+	}
+}
+
+func TestSkipReason(t *testing.T) {
+	pf := &pathFilter{
+		extensions: []string{".jpg", ".png"},
+		include:    parseRules([]string{"photos/"}),
+		exclude:    parseRules([]string{"*.thumb.jpg"}),
+	}
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"photos/a.jpg", ""},
+		{"photos/A.JPG", ""},
+		{"/photos/a.png", ""},
+		{"photos/a.txt", "extension filtered"},
+		{"other/a.jpg", "not included"},
+		{"photos/a.thumb.jpg", "excluded"},
+	}
+	for _, tt := range tests {
+		if got := pf.skipReason(tt.path); got != tt.want {
+			t.Errorf("skipReason(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestEntryReason(t *testing.T) {
+	ignored := parseRules([]string{"*.tmp"})
+	tests := []struct {
+		hidden bool
+		name   string
+		want   string
+	}{
+		{false, "a.jpg", ""},
+		{false, ".a.jpg", "hidden"},
+		{true, ".a.jpg", ""},
+		{true, "a.tmp", "ignored"},
+	}
+	for _, tt := range tests {
+		pf := &pathFilter{hidden: tt.hidden}
+		if got := pf.entryReason(tt.name, ignored); got != tt.want {
+			t.Errorf("entryReason(%q) with hidden=%v = %q, want %q", tt.name, tt.hidden, got, tt.want)
+		}
+	}
+}
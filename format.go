@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// signature describes how to recognize an image format by its leading bytes.
+type signature struct {
+	format string
+	// native reports whether image.DecodeConfig is able to handle the format.
+	native bool
+	match  func(header []byte) bool
+}
+
+// signatures lists all formats we can recognize by content.
+// Formats Go can't decode are still recognized, so the previewer gets a chance.
+var signatures = []signature{
+	{"png", true, prefix("\x89PNG\r\n\x1a\n")},
+	{"jpeg", true, prefix("\xff\xd8\xff")},
+	{"gif", true, func(h []byte) bool {
+		return bytes.HasPrefix(h, []byte("GIF87a")) || bytes.HasPrefix(h, []byte("GIF89a"))
+	}},
+	{"bmp", true, prefix("BM")},
+	{"tiff", true, func(h []byte) bool {
+		return bytes.HasPrefix(h, []byte("II*\x00")) || bytes.HasPrefix(h, []byte("MM\x00*"))
+	}},
+	{"webp", true, func(h []byte) bool {
+		return len(h) >= 12 && string(h[:4]) == "RIFF" && string(h[8:12]) == "WEBP"
+	}},
+	{"avif", false, ftypBrand("avif", "avis")},
+	{"heic", false, ftypBrand("heic", "heix", "hevc", "hevx", "heim", "heis", "mif1", "msf1")},
+	{"jxl", false, func(h []byte) bool {
+		return bytes.HasPrefix(h, []byte("\xff\x0a")) ||
+			bytes.HasPrefix(h, []byte("\x00\x00\x00\x0cJXL \r\n\x87\n"))
+	}},
+	{"pdf", false, prefix("%PDF-")},
+	{"svg", false, isSVG},
+}
+
+// sniffFormat returns the name of the format header belongs to,
+// along with whether Go can decode it. It returns "" if nothing matches.
+func sniffFormat(header []byte) (string, bool) {
+	for _, s := range signatures {
+		if s.match(header) {
+			return s.format, s.native
+		}
+	}
+	return "", false
+}
+
+func prefix(magic string) func([]byte) bool {
+	return func(h []byte) bool {
+		return bytes.HasPrefix(h, []byte(magic))
+	}
+}
+
+// ftypBrand matches ISO base media files (HEIF, AVIF, ...) whose major
+// or compatible brands contain one of brands.
+// Major brands are checked first, so AVIF files branded as mif1 are not
+// mistaken for HEIC.
+func ftypBrand(brands ...string) func([]byte) bool {
+	return func(h []byte) bool {
+		if len(h) < 16 || string(h[4:8]) != "ftyp" {
+			return false
+		}
+		size := min(int(binary.BigEndian.Uint32(h[:4])), len(h))
+		major := string(h[8:12])
+		for _, b := range brands {
+			if major == b {
+				return true
+			}
+		}
+		// A well known major brand means the file belongs to another format.
+		if _, ok := sniffBrand(major); ok {
+			return false
+		}
+		for i := 16; i+4 <= size; i += 4 {
+			for _, b := range brands {
+				if string(h[i:i+4]) == b {
+					return true
+				}
+			}
+		}
+		return false
+	}
+}
+
+// sniffBrand reports the format belonging to a major ftyp brand.
+func sniffBrand(brand string) (string, bool) {
+	switch brand {
+	case "avif", "avis":
+		return "avif", true
+	case "heic", "heix", "hevc", "hevx", "heim", "heis":
+		return "heic", true
+	}
+	return "", false
+}
+
+// isSVG reports whether h looks like the start of an SVG document.
+func isSVG(h []byte) bool {
+	h = bytes.TrimPrefix(h, []byte("\xef\xbb\xbf"))
+	h = bytes.TrimLeft(h, " \t\r\n")
+	if !bytes.HasPrefix(h, []byte("<")) {
+		return false
+	}
+	// Skip XML declarations, comments and doctypes.
+	return bytes.Contains(h, []byte("<svg"))
+}
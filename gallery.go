@@ -0,0 +1,293 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// maxThumbs bounds the number of thumbnails kept in memory.
+// Thumbnails of the current page are always kept.
+const maxThumbs = 1024
+
+// gallery holds the state of the thumbnail grid.
+// The cursor is the current picture.
+type gallery struct {
+	// tileW and tileH are the size of a tile in cells, including
+	// the gap to the next one and the label below the thumbnail.
+	tileW, tileH int
+	// cols and rows are the number of tiles across and down.
+	cols, rows   int
+	cellW, cellH int
+	// top is the index of the first picture shown.
+	top int
+	// drawnTop is the top of the page on screen, -1 if it has to be redrawn.
+	drawnTop int
+	// drawn holds the pictures whose thumbnails are on screen.
+	drawn map[*picture]bool
+	dirty bool
+}
+
+// layout computes the grid for a screen of cols x rows cells.
+func (g *gallery) layout(cols, rows, thumbsize int) {
+	g.cellW, g.cellH = cellSize(int(os.Stdout.Fd()))
+	if g.cellW == 0 || g.cellH == 0 {
+		g.cellW, g.cellH = defaultCellWidth, defaultCellHeight
+	}
+	g.tileW = min(max(thumbsize, 4), cols)
+	// Thumbnails get a square area, plus a row for the label.
+	g.tileH = max(int(math.Round(float64((g.tileW-1)*g.cellW)/float64(g.cellH))), 1) + 1
+	g.cols = max(cols/g.tileW, 1)
+	g.rows = max((rows-1)/g.tileH, 1)
+	g.drawnTop = -1
+}
+
+func (g *gallery) pageSize() int {
+	return g.cols * g.rows
+}
+
+// thumbPixels returns the size of the thumbnails to generate.
+func (g *gallery) thumbPixels() int {
+	return max((g.tileW-1)*g.cellW, (g.tileH-1)*g.cellH)
+}
+
+// tileArea returns the part of the screen the thumbnail of tile i is drawn into.
+func (g *gallery) tileArea(i int) area {
+	return area{
+		x:     (i % g.cols) * g.tileW,
+		y:     (i / g.cols) * g.tileH,
+		cols:  g.tileW - 1,
+		rows:  g.tileH - 1,
+		cellW: g.cellW,
+		cellH: g.cellH,
+	}
+}
+
+// openGallery switches to the thumbnail grid.
+func (v *viewer) openGallery() {
+	v.gallery = &gallery{}
+	v.zoom = nil
+	v.player = nil
+	v.shown = nil
+	v.gallery.layout(v.cols, v.rows, v.opt.thumbsize)
+	if v.thumbs == nil {
+		v.thumbs = newThumbnailer(v.gallery.thumbPixels())
+		v.thumbCache = make(map[*picture]thumbResult)
+	}
+}
+
+// closeGallery switches back to the current picture.
+func (v *viewer) closeGallery() {
+	v.gallery = nil
+	v.clearScreen()
+	v.shown = nil
+}
+
+// visible returns the pictures on the current page of the gallery.
+func (v *viewer) visible() []*picture {
+	g := v.gallery
+	// Pictures might have been removed since the page was laid out.
+	top := min(g.top, len(v.pics))
+	return v.pics[top:min(top+g.pageSize(), len(v.pics))]
+}
+
+// handleThumb stores a thumbnail generated in the background.
+func (v *viewer) handleThumb(res thumbResult) {
+	if res.err != nil {
+		warnp(res.err)
+	}
+	v.thumbCache[res.pic] = res
+	if v.gallery != nil && slices.Contains(v.visible(), res.pic) {
+		v.gallery.dirty = true
+	}
+}
+
+// requestThumbs asks for the thumbnails of the current and the next page.
+func (v *viewer) requestThumbs() {
+	g := v.gallery
+	end := min(g.top+2*g.pageSize(), len(v.pics))
+	var want []*picture
+	for _, pic := range v.pics[g.top:end] {
+		// The orientation is only known once loaded.
+		if _, ok := v.thumbCache[pic]; !ok && pic.loaded {
+			want = append(want, pic)
+		}
+	}
+	v.thumbs.request(want)
+
+	if len(v.thumbCache) > maxThumbs {
+		keep := v.pics[g.top:end]
+		for pic := range v.thumbCache {
+			if !slices.Contains(keep, pic) {
+				delete(v.thumbCache, pic)
+			}
+		}
+	}
+}
+
+// handleGalleryKey executes the action bound to key in the gallery.
+// It reports whether key was handled.
+func (v *viewer) handleGalleryKey(key rune, count int) bool {
+	g := v.gallery
+	n := max(count, 1)
+	last := len(v.pics) - 1
+	switch key {
+	case 'h':
+		v.curr = max(v.curr-n, 0)
+	case 'l':
+		v.curr = min(v.curr+n, last)
+	case 'k':
+		v.curr = max(v.curr-n*g.cols, 0)
+	case 'j':
+		v.curr = min(v.curr+n*g.cols, last)
+	case 'b', 0x02: // ^B
+		v.curr = max(v.curr-n*g.pageSize(), 0)
+	case 'f', 0x06: // ^F
+		v.curr = min(v.curr+n*g.pageSize(), last)
+	case 'g':
+		v.curr = 0
+	case 'G':
+		if count == 0 {
+			v.curr = last
+		} else {
+			v.curr = min(count, len(v.pics)) - 1
+		}
+	case 'm':
+		v.toggleMark(v.pics[v.curr])
+	case '\r', '\n', 't', 'q', '\033':
+		v.closeGallery()
+		return true
+	case '?', ':':
+		// Overlays clear the screen.
+		g.drawnTop = -1
+		return false
+	case 'c':
+		// Compare the pictures marked in the gallery.
+		v.closeGallery()
+		return false
+	}
+	// Everything else doesn't make sense in the gallery.
+	g.dirty = true
+	return true
+}
+
+// drawGallery shows the page of thumbnails containing the cursor.
+func (v *viewer) drawGallery() error {
+	g := v.gallery
+	cols, rows, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil {
+		return err
+	}
+	if cols != v.cols || rows != v.rows {
+		v.cols, v.rows = cols, rows
+		g.layout(cols, rows, v.opt.thumbsize)
+	}
+
+	page := g.pageSize()
+	if v.curr < g.top {
+		g.top = v.curr - v.curr%g.cols
+	} else if v.curr >= g.top+page {
+		g.top = (v.curr/g.cols - g.rows + 1) * g.cols
+	}
+	if g.top != g.drawnTop {
+		v.clearScreen()
+		g.drawnTop = g.top
+		g.drawn = make(map[*picture]bool)
+		g.dirty = true
+		v.statusDirty = true
+	}
+	v.requestThumbs()
+
+	if g.dirty {
+		g.dirty = false
+		for i, pic := range v.visible() {
+			a := g.tileArea(i)
+			if th, ok := v.thumbCache[pic]; ok && th.err == nil && !g.drawn[pic] {
+				v.drawThumb(pic, th, a)
+				g.drawn[pic] = true
+			}
+			v.drawLabel(pic, a)
+		}
+	}
+	if v.statusDirty {
+		v.drawStatus()
+	}
+	return nil
+}
+
+// drawThumb draws the thumbnail of pic into a.
+func (v *viewer) drawThumb(pic *picture, th thumbResult, a area) {
+	if v.render != nil {
+		img := orient(th.img, pic.userOrientation)
+		os.Stdout.Write(v.render.encode(img, a, fitArea(img, a)))
+		return
+	}
+	if th.path == "" {
+		return
+	}
+	moveCursor(a.y+1, a.x+1)
+	p := preview{
+		path: th.path,
+		// Thumbnails already account for the Exif orientation.
+		orientation:     max(pic.userOrientation, orientNormal),
+		userOrientation: pic.userOrientation,
+		cols:            a.cols,
+		rows:            a.rows + 2, // %r leaves room for the statusline
+		x:               a.x,
+		y:               a.y,
+		zoom:            100,
+	}
+	if err := execCmd(generateCmd(v.opt.previewer, p)); err != nil {
+		errorf("displaying thumbnail: %s", err)
+	}
+}
+
+// drawLabel prints the name of pic below its thumbnail,
+// highlighting the cursor and marked pictures.
+func (v *viewer) drawLabel(pic *picture, a area) {
+	name := pic.name
+	if pic.marked {
+		name = "*" + name
+	}
+	name = truncateWidth(name, a.cols)
+	label := name + strings.Repeat(" ", a.cols-displayWidth(name))
+	if pic == v.pics[v.curr] {
+		label = "\033[7m" + label + "\033[0m"
+	}
+	printAt(a.y+a.rows+1, a.x+1, label)
+}
+
+// clearScreen removes everything drawn so far.
+func (v *viewer) clearScreen() {
+	if v.render != nil {
+		os.Stdout.Write(v.render.clear())
+		return
+	}
+	if err := execCmd(generateCmd(v.opt.cleaner, preview{cols: v.cols, rows: v.rows, zoom: 100})); err != nil {
+		errorf("cleaning screen: %s", err)
+		v.errMsg = "Error clearing screen"
+	}
+	clear()
+}
+
+// toggleMark marks pic or removes its mark.
+func (v *viewer) toggleMark(pic *picture) {
+	pic.marked = !pic.marked
+	if pic.marked {
+		v.marked++
+	} else {
+		v.marked--
+	}
+}
+
+// markedSummary describes the number of marked pictures for the statusline.
+func (v *viewer) markedSummary() string {
+	if v.marked == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d marked", v.marked)
+}
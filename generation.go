@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// generation holds the parameters an image was generated with,
+// as stored in PNG text chunks by Stable Diffusion tools.
+type generation struct {
+	prompt, negative string
+	params           []param
+}
+
+type param struct {
+	name, value string
+}
+
+// parseGeneration returns the generation parameters found in the PNG
+// text chunks text, or nil if there are none. AUTOMATIC1111 style
+// "parameters" and ComfyUI "prompt" chunks are supported.
+func parseGeneration(text map[string]string) *generation {
+	var g *generation
+	if s, ok := text["parameters"]; ok {
+		g = parseParameters(s)
+	} else if s, ok := text["prompt"]; ok {
+		g = comfyGeneration(s)
+	}
+	if g == nil {
+		return nil
+	}
+	// Unquoting and JSON decoding may bring back control characters.
+	// Parameters are shown on a single line each.
+	g.prompt, g.negative = cleanText(g.prompt), cleanText(g.negative)
+	for i, p := range g.params {
+		g.params[i] = param{cleanText(p.name), strings.Join(strings.Fields(cleanText(p.value)), " ")}
+	}
+	return g
+}
+
+// parseParameters parses the format written by AUTOMATIC1111 and
+// compatible tools: the prompt, the negative prompt prefixed by
+// "Negative prompt: " and a last line of comma separated parameters.
+func parseParameters(s string) *generation {
+	g := &generation{}
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	if last := lines[len(lines)-1]; strings.HasPrefix(last, "Steps: ") {
+		g.params = splitParams(last)
+		lines = lines[:len(lines)-1]
+	}
+	neg := slices.IndexFunc(lines, func(l string) bool {
+		return strings.HasPrefix(l, "Negative prompt:")
+	})
+	if neg >= 0 {
+		lines[neg] = strings.TrimPrefix(lines[neg], "Negative prompt:")
+		g.negative = strings.TrimSpace(strings.Join(lines[neg:], "\n"))
+		lines = lines[:neg]
+	}
+	g.prompt = strings.TrimSpace(strings.Join(lines, "\n"))
+	return g
+}
+
+// splitParams splits "Steps: 20, Sampler: Euler a, ..." into parameters.
+// Values containing commas are quoted.
+func splitParams(s string) []param {
+	var params []param
+	for s != "" {
+		name, rest, ok := strings.Cut(s, ": ")
+		if !ok {
+			break
+		}
+		value := ""
+		if q, err := strconv.QuotedPrefix(rest); err == nil && rest[0] == '"' {
+			value, _ = strconv.Unquote(q)
+			rest = strings.TrimPrefix(rest[len(q):], ", ")
+		} else {
+			value, rest, _ = strings.Cut(rest, ", ")
+		}
+		params = append(params, param{strings.TrimSpace(name), value})
+		s = rest
+	}
+	return params
+}
+
+// comfyNode is a node of the API format graph ComfyUI stores as "prompt".
+type comfyNode struct {
+	ClassType string                     `json:"class_type"`
+	Inputs    map[string]json.RawMessage `json:"inputs"`
+}
+
+// comfyParams names the sampler inputs worth showing, in order.
+var comfyParams = []struct{ input, name string }{
+	{"steps", "Steps"},
+	{"sampler_name", "Sampler"},
+	{"scheduler", "Scheduler"},
+	{"cfg", "CFG scale"},
+	{"seed", "Seed"},
+	{"noise_seed", "Seed"},
+	{"denoise", "Denoising strength"},
+}
+
+// comfyGeneration extracts the prompts and sampler settings of the first
+// sampler in the ComfyUI graph s.
+func comfyGeneration(s string) *generation {
+	var nodes map[string]comfyNode
+	if json.Unmarshal([]byte(s), &nodes) != nil {
+		return nil
+	}
+	g := &generation{}
+	// Node IDs are numbers, so sort them numerically to find the first.
+	ids := slices.SortedFunc(maps.Keys(nodes), func(a, b string) int {
+		return cmp.Or(cmp.Compare(len(a), len(b)), strings.Compare(a, b))
+	})
+	for _, id := range ids {
+		n := nodes[id]
+		if !strings.Contains(n.ClassType, "KSampler") {
+			continue
+		}
+		g.prompt = comfyText(nodes, n.Inputs["positive"], 0)
+		g.negative = comfyText(nodes, n.Inputs["negative"], 0)
+		for _, p := range comfyParams {
+			if v := comfyValue(n.Inputs[p.input]); v != "" {
+				g.params = append(g.params, param{p.name, v})
+			}
+		}
+		break
+	}
+	for _, id := range ids {
+		n := nodes[id]
+		switch {
+		case strings.Contains(n.ClassType, "EmptyLatentImage"):
+			w, h := comfyValue(n.Inputs["width"]), comfyValue(n.Inputs["height"])
+			if w != "" && h != "" {
+				g.params = append(g.params, param{"Size", w + "x" + h})
+			}
+		case strings.HasPrefix(n.ClassType, "CheckpointLoader"), n.ClassType == "UNETLoader":
+			for _, in := range []string{"ckpt_name", "unet_name"} {
+				if v := comfyValue(n.Inputs[in]); v != "" {
+					g.params = append(g.params, param{"Model", v})
+				}
+			}
+		}
+	}
+	if g.prompt == "" && g.negative == "" && len(g.params) == 0 {
+		return nil
+	}
+	return g
+}
+
+// maxComfyDepth bounds how far links are followed looking for prompts.
+const maxComfyDepth = 8
+
+// comfyText returns the text of the node the input in links to,
+// following nodes combining or passing on text.
+func comfyText(nodes map[string]comfyNode, in json.RawMessage, depth int) string {
+	// Links are [node ID, output index].
+	var link []any
+	if depth > maxComfyDepth || json.Unmarshal(in, &link) != nil || len(link) != 2 {
+		return ""
+	}
+	id, ok := link[0].(string)
+	if !ok {
+		return ""
+	}
+	n := nodes[id]
+	var texts []string
+	for _, name := range []string{"text", "text_g", "text_l", "string", "value", "conditioning", "conditioning_1", "conditioning_2"} {
+		raw, ok := n.Inputs[name]
+		if !ok {
+			continue
+		}
+		var s string
+		if json.Unmarshal(raw, &s) != nil {
+			s = comfyText(nodes, raw, depth+1)
+		}
+		if s = strings.TrimSpace(s); s != "" && !slices.Contains(texts, s) {
+			texts = append(texts, s)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// comfyValue returns the input in as text if it is a string or number.
+func comfyValue(in json.RawMessage) string {
+	d := json.NewDecoder(bytes.NewReader(in))
+	d.UseNumber()
+	var v any
+	if d.Decode(&v) != nil {
+		return ""
+	}
+	switch v := v.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	}
+	return ""
+}
+
+// generationLines formats the generation parameters and the other text
+// chunks of pic, wrapped to width.
+func generationLines(pic *picture, width int) []string {
+	text := pic.loadMetadata().text
+	var lines []string
+	section := func(title string, body []string) {
+		if len(body) == 0 {
+			return
+		}
+		if len(lines) > 0 {
+			lines = append(lines, "")
+		}
+		lines = append(append(lines, title), body...)
+	}
+	wrap := func(s string) []string {
+		if s == "" {
+			return nil
+		}
+		var body []string
+		for _, l := range strings.Split(s, "\n") {
+			body = append(body, wrapWidth(l, "  ", width)...)
+		}
+		return body
+	}
+
+	g := parseGeneration(text)
+	if g != nil {
+		section("Prompt", wrap(g.prompt))
+		section("Negative prompt", wrap(g.negative))
+		nameWidth := 0
+		for _, p := range g.params {
+			nameWidth = max(nameWidth, displayWidth(p.name))
+		}
+		var params []string
+		for _, p := range g.params {
+			params = append(params, fmt.Sprintf("  %-*s  %s", nameWidth, p.name, p.value))
+		}
+		section("Parameters", params)
+	}
+	for _, k := range slices.Sorted(maps.Keys(text)) {
+		if g != nil && (k == "parameters" || k == "prompt") {
+			continue
+		}
+		v := text[k]
+		// ComfyUI stores its workflow as JSON in a single line.
+		var b bytes.Buffer
+		if json.Indent(&b, []byte(v), "", "  ") == nil {
+			v = b.String()
+		}
+		section(k, wrap(v))
+	}
+	return lines
+}
+
+// wrapWidth breaks s into lines of at most width columns at spaces,
+// starting every line with indent. Words too long for a line are split.
+func wrapWidth(s, indent string, width int) []string {
+	width = max(width-displayWidth(indent), 10)
+	var lines []string
+	line, lineWidth := "", 0
+	// Keep leading spaces, like the indentation of JSON.
+	lead := s[:len(s)-len(strings.TrimLeft(s, " "))]
+	for i, word := range strings.Fields(s) {
+		if i == 0 {
+			word = lead + word
+		}
+		w := displayWidth(word)
+		if lineWidth > 0 && lineWidth+1+w > width {
+			lines = append(lines, indent+line)
+			line, lineWidth = "", 0
+		}
+		for w > width {
+			cut, cutWidth := 0, 0
+			for i, r := range word {
+				if cutWidth+runeWidth(r) > width {
+					cut = i
+					break
+				}
+				cutWidth += runeWidth(r)
+			}
+			lines = append(lines, indent+word[:cut])
+			word, w = word[cut:], w-cutWidth
+		}
+		if lineWidth > 0 {
+			line += " "
+			lineWidth++
+		}
+		line += word
+		lineWidth += w
+	}
+	return append(lines, indent+line)
+}
+
+// copyPrompt copies the prompt of pic to the clipboard.
+func (v *viewer) copyPrompt(pic *picture) {
+	g := parseGeneration(pic.loadMetadata().text)
+	if g == nil || g.prompt == "" {
+		v.errMsg = "No prompt found"
+		return
+	}
+	copyToClipboard(g.prompt)
+	v.infoMsg = "Copied prompt to clipboard"
+}
+
+// copyToClipboard sets the clipboard of the terminal to s using OSC 52,
+// which most terminals support, even over SSH.
+func copyToClipboard(s string) {
+	fmt.Fprintf(os.Stdout, "\033]52;c;%s\a", base64.StdEncoding.EncodeToString([]byte(s)))
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseGeneration(t *testing.T) {
+	tests := []struct {
+		name string
+		text map[string]string
+		want *generation
+	}{
+		{
+			name: "parameters",
+			text: map[string]string{"parameters": "a cat\non a sofa\nNegative prompt: blurry\nSteps: 20, Sampler: Euler a, CFG scale: 7, Seed: 42, Size: 512x768"},
+			want: &generation{
+				prompt:   "a cat\non a sofa",
+				negative: "blurry",
+				params: []param{
+					{"Steps", "20"}, {"Sampler", "Euler a"}, {"CFG scale", "7"},
+					{"Seed", "42"}, {"Size", "512x768"},
+				},
+			},
+		},
+		{
+			name: "quoted parameter",
+			text: map[string]string{"parameters": "a dog\nSteps: 30, Lora hashes: \"a: 1, b: 2\", Seed: 1"},
+			want: &generation{
+				prompt: "a dog",
+				params: []param{{"Steps", "30"}, {"Lora hashes", "a: 1, b: 2"}, {"Seed", "1"}},
+			},
+		},
+		{
+			name: "prompt only",
+			text: map[string]string{"parameters": "  just a prompt  "},
+			want: &generation{prompt: "just a prompt"},
+		},
+		{
+			name: "control characters",
+			text: map[string]string{"parameters": "a \x1b[31mred\x1b[0m cat\nSteps: 20, Note: \"two\\nlines\""},
+			want: &generation{
+				prompt: "a [31mred[0m cat",
+				params: []param{{"Steps", "20"}, {"Note", "two lines"}},
+			},
+		},
+		{
+			name: "comfy",
+			text: map[string]string{"prompt": `{
+				"3": {"class_type": "KSampler", "inputs": {"seed": 7, "steps": 25, "cfg": 6.5, "sampler_name": "euler", "positive": ["6", 0], "negative": ["7", 0]}},
+				"4": {"class_type": "CheckpointLoaderSimple", "inputs": {"ckpt_name": "model.safetensors"}},
+				"5": {"class_type": "EmptyLatentImage", "inputs": {"width": 1024, "height": 768}},
+				"6": {"class_type": "CLIPTextEncode", "inputs": {"text": "a fox", "clip": ["4", 1]}},
+				"7": {"class_type": "CLIPTextEncode", "inputs": {"text": "ugly", "clip": ["4", 1]}}
+			}`},
+			want: &generation{
+				prompt:   "a fox",
+				negative: "ugly",
+				params: []param{
+					{"Steps", "25"}, {"Sampler", "euler"}, {"CFG scale", "6.5"}, {"Seed", "7"},
+					{"Model", "model.safetensors"}, {"Size", "1024x768"},
+				},
+			},
+		},
+		{
+			name: "comfy linked text",
+			text: map[string]string{"prompt": `{
+				"10": {"class_type": "KSampler", "inputs": {"positive": ["11", 0]}},
+				"11": {"class_type": "CLIPTextEncode", "inputs": {"text": ["12", 0]}},
+				"12": {"class_type": "PrimitiveString", "inputs": {"value": "a linked owl"}}
+			}`},
+			want: &generation{prompt: "a linked owl"},
+		},
+		{
+			name: "comfy cycle",
+			text: map[string]string{"prompt": `{
+				"1": {"class_type": "KSampler", "inputs": {"positive": ["2", 0], "steps": 5}},
+				"2": {"class_type": "Concat", "inputs": {"string": ["2", 0]}}
+			}`},
+			want: &generation{params: []param{{"Steps", "5"}}},
+		},
+		{"comfy without sampler", map[string]string{"prompt": `{"1": {"class_type": "Note", "inputs": {}}}`}, nil},
+		{"invalid comfy", map[string]string{"prompt": "not json"}, nil},
+		{"none", map[string]string{"Software": "GIMP"}, nil},
+	}
+	for _, tt := range tests {
+		got := parseGeneration(tt.text)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: parseGeneration = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWrapWidth(t *testing.T) {
+	tests := []struct {
+		s     string
+		width int
+		want  []string
+	}{
+		{"a b c", 20, []string{"  a b c"}},
+		{"one two three four", 12, []string{"  one two", "  three four"}},
+		{"    {", 20, []string{"      {"}},
+		{"abcdefghijklmnop", 12, []string{"  abcdefghij", "  klmnop"}},
+		{"", 20, []string{"  "}},
+	}
+	for _, tt := range tests {
+		if got := wrapWidth(tt.s, "  ", tt.width); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("wrapWidth(%q, %d) = %q, want %q", tt.s, tt.width, got, tt.want)
+		}
+	}
+}
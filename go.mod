@@ -4,7 +4,6 @@ go 1.24.5
 
 require (
 	golang.org/x/image v0.30.0
+	golang.org/x/sys v0.34.0
 	golang.org/x/term v0.33.0
 )
-
-require golang.org/x/sys v0.34.0 // indirect
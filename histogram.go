@@ -0,0 +1,273 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"os"
+	"slices"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// Channels of imageStats.
+const (
+	chanRed = iota
+	chanGreen
+	chanBlue
+	chanLuma
+	numChannels
+)
+
+var channelNames = [numChannels]string{"Red", "Green", "Blue", "Luminance"}
+
+// histogramRows is the height of a histogram in rows of text.
+const histogramRows = 8
+
+// maxDominant bounds the number of dominant colors listed.
+const maxDominant = 5
+
+// imageStats holds the histograms and statistics of an image.
+// Colors are not premultiplied by alpha, and fully transparent
+// pixels are left out.
+type imageStats struct {
+	hist [numChannels][256]int
+	// total counts all pixels, visible only the ones not fully transparent.
+	total, visible int
+	// translucent counts the pixels neither opaque nor fully transparent.
+	translucent int
+	dominant    []colorShare
+}
+
+// colorShare is a color along with the share of pixels close to it.
+type colorShare struct {
+	r, g, b uint8
+	share   float64
+}
+
+// computeStats counts the pixels of img.
+func computeStats(img image.Image) *imageStats {
+	b := img.Bounds()
+	p, ok := img.(*image.NRGBA)
+	if !ok || b.Min != (image.Point{}) {
+		p = image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(p, p.Bounds(), img, b.Min, draw.Src)
+	}
+
+	s := &imageStats{total: b.Dx() * b.Dy()}
+	// Colors are grouped by their upper 4 bits for finding dominant ones.
+	type bucket struct{ n, r, g, b int }
+	buckets := make([]bucket, 1<<12)
+	for y := range b.Dy() {
+		row := p.Pix[y*p.Stride : y*p.Stride+b.Dx()*4]
+		for o := 0; o < len(row); o += 4 {
+			px := row[o : o+4]
+			switch px[3] {
+			case 0:
+				continue
+			case 0xff:
+			default:
+				s.translucent++
+			}
+			s.visible++
+			s.hist[chanRed][px[0]]++
+			s.hist[chanGreen][px[1]]++
+			s.hist[chanBlue][px[2]]++
+			s.hist[chanLuma][int(luma(px)+0.5)]++
+
+			k := &buckets[int(px[0]>>4)<<8|int(px[1]>>4)<<4|int(px[2]>>4)]
+			k.n++
+			k.r += int(px[0])
+			k.g += int(px[1])
+			k.b += int(px[2])
+		}
+	}
+
+	slices.SortFunc(buckets, func(a, b bucket) int {
+		return cmp.Compare(b.n, a.n)
+	})
+	for _, k := range buckets[:maxDominant] {
+		if k.n == 0 {
+			break
+		}
+		s.dominant = append(s.dominant, colorShare{
+			r:     uint8(k.r / k.n),
+			g:     uint8(k.g / k.n),
+			b:     uint8(k.b / k.n),
+			share: float64(k.n) / float64(s.visible),
+		})
+	}
+	return s
+}
+
+// statsResult carries the statistics computed for pic.
+type statsResult struct {
+	pic   *picture
+	stats *imageStats
+	err   error
+}
+
+// loadStats decodes pic and sends its statistics to results.
+func loadStats(pic *picture, results chan<- statsResult) {
+	img, err := decodeStored(pic)
+	if err != nil {
+		results <- statsResult{pic: pic, err: err}
+		return
+	}
+	results <- statsResult{pic: pic, stats: computeStats(img)}
+}
+
+// handleStats shows the histogram once it is computed,
+// unless the user moved on in the meantime.
+func (v *viewer) handleStats(in *input, res statsResult) error {
+	if v.statsPending != res.pic {
+		return nil
+	}
+	v.statsPending = nil
+	v.statusDirty = true
+	if res.pic != v.pics[v.curr] || v.compare != nil || v.diff != nil {
+		return nil
+	}
+	v.infoMsg = ""
+	if res.err != nil {
+		errorf("decoding image: %s", res.err)
+		v.errMsg = fmt.Sprintf("Error decoding %q", res.pic.name)
+		return nil
+	}
+	cols, _, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil {
+		return err
+	}
+	lines := statsLines(res.stats, cols)
+	if _, err := showPager(in, "Histogram: "+res.pic.name, lines, "H"); err != nil {
+		return err
+	}
+	v.shown = nil
+	return nil
+}
+
+// mean returns the average value of channel c.
+func (s *imageStats) mean(c int) float64 {
+	sum, n := 0, 0
+	for v, count := range s.hist[c] {
+		sum += v * count
+		n += count
+	}
+	if n == 0 {
+		return 0
+	}
+	return float64(sum) / float64(n)
+}
+
+// clipped returns the share of pixels at the lowest and highest value
+// of channel c.
+func (s *imageStats) clipped(c int) (float64, float64) {
+	if s.visible == 0 {
+		return 0, 0
+	}
+	n := float64(s.visible)
+	return float64(s.hist[c][0]) / n, float64(s.hist[c][255]) / n
+}
+
+// summary describes what is notable about the image, like being blank.
+func (s *imageStats) summary() string {
+	switch {
+	case s.total == 0:
+		return "empty image"
+	case s.visible == 0:
+		return "fully transparent"
+	case len(s.dominant) > 0 && s.dominant[0].share == 1:
+		// All pixels fall into the same bucket, so check the values as well.
+		for c := chanRed; c <= chanBlue; c++ {
+			if slices.ContainsFunc(s.hist[c][:], func(n int) bool { return n > 0 && n < s.visible }) {
+				return "nearly a single color"
+			}
+		}
+		return "a single color"
+	}
+	return ""
+}
+
+// alphaSummary describes the transparency of the image.
+func (s *imageStats) alphaSummary() string {
+	transparent := s.total - s.visible
+	if transparent == 0 && s.translucent == 0 {
+		return "none (opaque)"
+	}
+	return fmt.Sprintf("%s transparent, %s translucent",
+		percent(transparent, s.total), percent(s.translucent, s.total))
+}
+
+func percent(n, total int) string {
+	return fmt.Sprintf("%.2f%%", float64(n)/float64(max(total, 1))*100)
+}
+
+// statsLines formats s, drawing histograms of width columns at most.
+func statsLines(s *imageStats, width int) []string {
+	var lines []string
+	if note := s.summary(); note != "" {
+		lines = append(lines, "Note: image is "+note, "")
+	}
+
+	lines = append(lines, fmt.Sprintf("%-10s  %6s  %8s  %8s", "", "mean", "clip low", "clip high"))
+	for c := chanRed; c <= chanLuma; c++ {
+		low, high := s.clipped(c)
+		lines = append(lines, fmt.Sprintf("%-10s  %6.1f  %7.2f%%  %7.2f%%",
+			channelNames[c], s.mean(c), low*100, high*100))
+	}
+	lines = append(lines, "", "Alpha       "+s.alphaSummary())
+
+	if len(s.dominant) > 0 {
+		lines = append(lines, "", "Dominant colors")
+		for _, d := range s.dominant {
+			lines = append(lines, fmt.Sprintf("  #%02x%02x%02x  %6.2f%%", d.r, d.g, d.b, d.share*100))
+		}
+	}
+
+	for c := chanRed; c <= chanLuma; c++ {
+		lines = append(lines, "", channelNames[c])
+		lines = append(lines, drawHistogram(s.hist[c], width)...)
+	}
+	return lines
+}
+
+// histogramBlocks fills a cell from the bottom in eighths.
+var histogramBlocks = []rune(" ▁▂▃▄▅▆▇█")
+
+// drawHistogram draws hist as bars of histogramRows rows, merging
+// values into at most width columns, followed by an axis.
+func drawHistogram(hist [256]int, width int) []string {
+	cols := min(max(width, 16), len(hist))
+	// Columns get an uneven number of values unless width divides 256,
+	// so use the average to avoid a comb pattern.
+	bars := make([]float64, cols)
+	values := make([]int, cols)
+	for v, n := range hist {
+		bars[v*cols/len(hist)] += float64(n)
+		values[v*cols/len(hist)]++
+	}
+	for i := range bars {
+		bars[i] /= float64(values[i])
+	}
+	top := slices.Max(bars)
+
+	lines := make([]string, histogramRows)
+	for row := range histogramRows {
+		var b strings.Builder
+		// Rows are counted from the bottom.
+		base := (histogramRows - 1 - row) * 8
+		for _, n := range bars {
+			eighths := 0
+			if top > 0 {
+				eighths = int(math.Ceil(n / top * histogramRows * 8))
+			}
+			b.WriteRune(histogramBlocks[min(max(eighths-base, 0), 8)])
+		}
+		lines[row] = b.String()
+	}
+	axis := "0" + strings.Repeat(" ", max(cols-4, 1)) + "255"
+	return append(lines, axis)
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// hashResult carries the SHA-256 of pic.
+type hashResult struct {
+	pic  *picture
+	hash string
+	err  error
+}
+
+// hashPicture reads pic and sends its SHA-256 to results.
+func hashPicture(pic *picture, results chan<- hashResult) {
+	rc, err := pic.open()
+	if err != nil {
+		results <- hashResult{pic: pic, err: err}
+		return
+	}
+	defer rc.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, rc); err != nil {
+		results <- hashResult{pic: pic, err: err}
+		return
+	}
+	results <- hashResult{pic: pic, hash: hex.EncodeToString(h.Sum(nil))}
+}
+
+// handleHash keeps the checksum of a picture and shows its info panel,
+// unless the user moved on in the meantime.
+func (v *viewer) handleHash(in *input, res hashResult) error {
+	if v.hashPending != res.pic {
+		return nil
+	}
+	v.hashPending = nil
+	v.statusDirty = true
+	if res.err != nil {
+		// The panel is still useful without it.
+		errorf("hashing %s: %s", res.pic.path, res.err)
+	} else {
+		res.pic.hash = res.hash
+	}
+	if res.pic != v.pics[v.curr] || v.compare != nil || v.diff != nil {
+		return nil
+	}
+	v.infoMsg = ""
+	return v.showInfo(in, res.pic)
+}
+
+// showInfo shows the info panel of pic.
+func (v *viewer) showInfo(in *input, pic *picture) error {
+	if _, err := showPager(in, "Info: "+pic.name, infoLines(pic), "i"); err != nil {
+		return err
+	}
+	v.shown = nil
+	return nil
+}
+
+// infoLines returns the details of pic shown in the info panel.
+func infoLines(pic *picture) []string {
+	var lines []string
+	add := func(label, value string) {
+		if value != "" {
+			lines = append(lines, fmt.Sprintf("%-13s %s", label, value))
+		}
+	}
+
+	if pic.archive != "" {
+		add("Archive", pic.archive)
+		add("Entry", pic.name)
+	} else {
+		add("Path", pic.path)
+	}
+	add("Format", pic.format)
+	if pic.width > 0 && pic.height > 0 {
+		dims := fmt.Sprintf("%dx%d", pic.width, pic.height)
+		if o := pic.displayOrientation(); o > orientNormal {
+			dims += fmt.Sprintf(" (orientation %d)", o)
+		}
+		add("Dimensions", dims)
+	}
+
+	if rc, err := pic.open(); err == nil {
+		if cfg, _, err := image.DecodeConfig(rc); err == nil {
+			add("Color model", colorModelName(cfg.ColorModel))
+		}
+		rc.Close()
+	}
+
+	// Entries inside archives share the details of the archive.
+	path := pic.path
+	if pic.archive != "" {
+		path = pic.archive
+	}
+	add("File size", fmt.Sprintf("%s (%d bytes)", humanReadable(pic.size), pic.size))
+	if info, err := os.Stat(path); err == nil {
+		add("Modified", info.ModTime().Format(time.DateTime))
+		add("Permissions", info.Mode().Perm().String())
+	}
+	add("SHA-256", pic.hash)
+
+	meta := pic.loadMetadata()
+	exif := meta.exif
+	if len(exif) > 0 {
+		lines = append(lines, "")
+	}
+	camera := exif["Model"]
+	// Most models include the make already.
+	if mk := exif["Make"]; !strings.HasPrefix(strings.ToLower(camera), strings.ToLower(mk)) {
+		camera = strings.TrimSpace(mk + " " + camera)
+	}
+	add("Camera", camera)
+	add("Lens", exif["LensModel"])
+	var exposure []string
+	if t := exif["ExposureTime"]; t != "" {
+		exposure = append(exposure, t+" s")
+	}
+	if f := exif["FNumber"]; f != "" {
+		exposure = append(exposure, "f/"+f)
+	}
+	if iso := exif["ISO"]; iso != "" {
+		exposure = append(exposure, "ISO "+iso)
+	}
+	if fl := exif["FocalLength"]; fl != "" {
+		exposure = append(exposure, fl+" mm")
+	}
+	add("Exposure", strings.Join(exposure, "  "))
+	add("Taken", exif["DateTimeOriginal"])
+	if lat, lon := exif["GPSLatitude"], exif["GPSLongitude"]; lat != "" && lon != "" {
+		pos := lat + ", " + lon
+		if alt := exif["GPSAltitude"]; alt != "" {
+			pos += fmt.Sprintf(" (%s m)", alt)
+		}
+		add("GPS", pos)
+	}
+
+	lines = appendFields(lines, "Exif", exif)
+	lines = appendFields(lines, "XMP", meta.xmp)
+	lines = appendFields(lines, "IPTC", meta.iptc)
+	lines = appendFields(lines, "PNG text", meta.text)
+	return lines
+}
+
+// appendFields adds a section listing fields sorted by name to lines,
+// unless there are none.
+func appendFields(lines []string, title string, fields map[string]string) []string {
+	if len(fields) == 0 {
+		return lines
+	}
+	lines = append(lines, "", title)
+	names := slices.Sorted(maps.Keys(fields))
+	width := 0
+	for _, name := range names {
+		width = max(width, len(name))
+	}
+	for _, name := range names {
+		// Long texts, like prompts, are shortened by the pager.
+		value := strings.Join(strings.Fields(fields[name]), " ")
+		lines = append(lines, fmt.Sprintf("  %-*s  %s", width, name, value))
+	}
+	return lines
+}
+
+// colorModelName describes m along with its bit depth.
+func colorModelName(m color.Model) string {
+	if p, ok := m.(color.Palette); ok {
+		return fmt.Sprintf("paletted, %d colors", len(p))
+	}
+	switch m {
+	case color.RGBAModel:
+		return "RGBA, 8 bits per channel"
+	case color.RGBA64Model:
+		return "RGBA, 16 bits per channel"
+	case color.NRGBAModel:
+		return "RGBA (non-premultiplied), 8 bits per channel"
+	case color.NRGBA64Model:
+		return "RGBA (non-premultiplied), 16 bits per channel"
+	case color.GrayModel:
+		return "grayscale, 8 bits"
+	case color.Gray16Model:
+		return "grayscale, 16 bits"
+	case color.YCbCrModel:
+		return "YCbCr, 8 bits per channel"
+	case color.NYCbCrAModel:
+		return "YCbCr with alpha, 8 bits per channel"
+	case color.CMYKModel:
+		return "CMYK, 8 bits per channel"
+	case color.AlphaModel:
+		return "alpha, 8 bits"
+	case color.Alpha16Model:
+		return "alpha, 16 bits"
+	}
+	return ""
+}
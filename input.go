@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"os"
+)
+
+// input reads keys from the terminal in the background,
+// so the main loop is free to wait for other events at the same time.
+type input struct {
+	keys chan rune
+	// err is set before keys is closed.
+	err error
+}
+
+func newInput(f *os.File) *input {
+	in := &input{keys: make(chan rune)}
+	go func() {
+		r := bufio.NewReader(f)
+		for {
+			ch, _, err := r.ReadRune()
+			if err != nil {
+				in.err = err
+				close(in.keys)
+				return
+			}
+			// Ignore escape sequences (e.g. arrow keys) as a whole.
+			if ch == '\033' && r.Buffered() > 0 {
+				skipEscapeSeq(r)
+				continue
+			}
+			in.keys <- ch
+		}
+	}()
+	return in
+}
+
+// readRune blocks until the next key is pressed.
+func (in *input) readRune() (rune, error) {
+	ch, ok := <-in.keys
+	if !ok {
+		return 0, in.err
+	}
+	return ch, nil
+}
+
+// readKey is like readRune, but also returns the count typed before the key.
+func (in *input) readKey() (rune, int, error) {
+	count := 0
+	for {
+		ch, err := in.readRune()
+		if err != nil {
+			return 0, 0, err
+		}
+		if !isDigit(ch) {
+			return ch, count, nil
+		}
+		count = count*10 + int(ch-'0')
+	}
+}
+
+func isDigit(ch rune) bool {
+	return ch >= '0' && ch <= '9'
+}
+
+// skipEscapeSeq consumes the remainder of an escape sequence
+// (e.g. arrow keys) after the leading ESC was read.
+func skipEscapeSeq(r *bufio.Reader) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return
+	}
+	switch b {
+	case '[':
+		// CSI: parameters and intermediates up to a final byte.
+		for {
+			b, err := r.ReadByte()
+			if err != nil || b >= 0x40 && b <= 0x7e {
+				return
+			}
+		}
+	case 'O':
+		r.ReadByte()
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"path/filepath"
+	"sync"
+)
+
+// loadWorkers bounds the number of files opened concurrently.
+// Loading is mostly waiting on disks (or network shares), not the CPU.
+const loadWorkers = 16
+
+// loadResult carries the metadata resolved for a pending picture.
+type loadResult struct {
+	pic  *picture
+	meta *picture
+	err  error
+}
+
+// pendingPicture returns a picture for path without touching the file.
+// Its metadata is filled in once loaded. Relative paths are resolved
+// against cwd, which saves looking it up for every single file.
+func pendingPicture(cwd, path string) *picture {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(cwd, path)
+	}
+	// The name shares its memory with the path.
+	return &picture{
+		name: filepath.Base(path),
+		path: path,
+	}
+}
+
+// apply copies the metadata of a loaded picture into p.
+func (p *picture) apply(meta *picture) {
+	p.size = meta.size
+	p.width = meta.width
+	p.height = meta.height
+	p.format = meta.format
+	p.orientation = meta.orientation
+	p.taken = meta.taken
+	p.loaded = true
+	// The file may have changed.
+	p.meta, p.hash = nil, ""
+}
+
+// loader resolves picture metadata using a bounded pool of workers.
+// Only the most recent request is worked on, so scrolling through
+// huge lists never queues up more than a window of files.
+type loader struct {
+	detect  string
+	cache   *metaCache
+	results chan loadResult
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	queue    []*picture
+	inflight map[*picture]bool
+}
+
+func newLoader(detect string, cache *metaCache) *loader {
+	l := &loader{
+		detect:   detect,
+		cache:    cache,
+		results:  make(chan loadResult, loadWorkers*4),
+		inflight: make(map[*picture]bool),
+	}
+	l.cond = sync.NewCond(&l.mu)
+	for range loadWorkers {
+		go l.work()
+	}
+	return l
+}
+
+// request replaces the queue with pics, in order of priority.
+// Pictures that are loaded or being loaded are skipped.
+// It must be called from the goroutine owning pics.
+func (l *loader) request(pics []*picture) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.queue = l.queue[:0]
+	for _, p := range pics {
+		if !p.loaded && !l.inflight[p] {
+			l.queue = append(l.queue, p)
+		}
+	}
+	l.cond.Broadcast()
+}
+
+// pending returns the number of pictures queued or being loaded.
+func (l *loader) pending() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.queue) + len(l.inflight)
+}
+
+func (l *loader) work() {
+	for {
+		l.mu.Lock()
+		for len(l.queue) == 0 {
+			l.cond.Wait()
+		}
+		p := l.queue[0]
+		l.queue = l.queue[1:]
+		l.inflight[p] = true
+		l.mu.Unlock()
+
+		var meta *picture
+		var err error
+		if p.entry != nil {
+			meta, err = loadEntry(p, l.detect, l.cache)
+		} else {
+			meta, err = newPicture(p.path, l.detect, l.cache)
+		}
+
+		l.mu.Lock()
+		delete(l.inflight, p)
+		l.mu.Unlock()
+		l.results <- loadResult{pic: p, meta: meta, err: err}
+	}
+}
+
+// window returns the pictures around pics[idx], nearest first.
+func window(pics []*picture, idx, size int) []*picture {
+	out := make([]*picture, 0, 2*size+1)
+	out = append(out, pics[idx])
+	for d := 1; d <= size; d++ {
+		if idx+d < len(pics) {
+			out = append(out, pics[idx+d])
+		}
+		if idx-d >= 0 {
+			out = append(out, pics[idx-d])
+		}
+	}
+	return out
+}
@@ -3,13 +3,17 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"cmp"
 	"errors"
 	"fmt"
 	"image"
 	_ "image/gif"
 	_ "image/jpeg"
 	_ "image/png"
+	"io"
+	"io/fs"
 	"math"
+	"math/rand/v2"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -38,6 +42,26 @@ type picture struct {
 	path          string
 	size          int64
 	width, height int
+	format        string
+	// orientation is the Exif orientation (0 if unknown).
+	// userOrientation holds rotations and flips done while viewing.
+	// width and height already account for both.
+	orientation     int
+	userOrientation int
+	// taken is the Exif date the photo was taken, empty if unknown.
+	taken string
+	// hash is the SHA-256 of the file, computed for the info panel.
+	hash string
+	// loaded reports whether the fields above have been resolved.
+	loaded bool
+	// archive is the absolute path of the archive containing the picture.
+	// In that case, name is the path of its entry and path is virtual.
+	archive string
+	entry   *archiveEntry
+	// marked is set by the user in gallery mode.
+	marked bool
+	// meta caches the embedded metadata, see loadMetadata.
+	meta *metadata
 }
 
 func main() {
@@ -50,6 +74,13 @@ func main() {
 		fmt.Println("spit", version())
 	case cli.printDefault:
 		fmt.Println(defaultConfig())
+	case cli.printDesktop:
+		entry, err := desktopEntry()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "spit: "+err.Error())
+			os.Exit(1)
+		}
+		fmt.Println(entry)
 	default:
 		if err := run(cli); err != nil {
 			errorp(err)
@@ -81,30 +112,160 @@ func run(cli flags) error {
 			return fmt.Errorf("loading config: %w", err)
 		}
 	}
+	// On Windows, trusting the shell with wildcards is optimistic. We don't.
+	cli.args = expandGlobs(cli.args, opt.hidden)
 
-	paths := pathsFromArgs(cli.args, opt.extensions)
-	pics := make([]*picture, 0, len(paths))
+	if cli.desktop {
+		opt.siblings = true
+		// Launched from a file manager or xdg-open, there is no terminal
+		// to draw to yet.
+		if !term.IsTerminal(int(os.Stdin.Fd())) {
+			return reexecInTerminal(opt.terminal)
+		}
+	}
 
+	filter := newPathFilter(opt, cli)
+	target := ""
+	if opt.siblings {
+		target, cli = expandSiblings(cli)
+	}
+	paths, skipped := pathsFromArgs(cli.args, filter)
+	if target != "" {
+		// The file we were started with should show up even if it
+		// would have been filtered out otherwise.
+		if i, found := slices.BinarySearch(paths, target); !found {
+			paths = slices.Insert(paths, i, target)
+			skipped = slices.DeleteFunc(skipped, func(s skippedFile) bool {
+				return s.path == target
+			})
+		}
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	// Metadata is only resolved once needed, so huge directories
+	// don't keep us from starting.
+	pics := make([]*picture, 0, len(paths))
 	for _, p := range paths {
-		pic, err := newPicture(p)
+		if !isArchive(p) {
+			pics = append(pics, pendingPicture(cwd, p))
+			continue
+		}
+		arc, entries, s, err := readArchive(p, filter)
 		if err != nil {
 			warnp(err)
-		} else if pic != nil {
-			pics = append(pics, pic)
+			skipped = append(skipped, rejectedFile(p, err))
+			continue
 		}
+		defer func() {
+			if err := arc.close(); err != nil {
+				errorf("closing archive: %s", err)
+			}
+		}()
+		pics = append(pics, entries...)
+		skipped = append(skipped, s...)
 	}
-	total := len(pics)
-	if total == 0 {
-		return fmt.Errorf("no images loaded")
+
+	seed := cli.seed
+	if !cli.hasSeed {
+		seed = rand.Uint64()
+	}
+	rng := newRand(seed)
+	if opt.sort == sortRandom {
+		// Log the seed, so the order can be reproduced.
+		infof("shuffling with seed %d", seed)
+		shuffle(pics, rng)
 	}
 
-	curr, err := startIndex(pics, cli.startIdx, cli.startPath)
-	if err != nil {
+	var cache *metaCache
+	if !cli.noCache && defaultCachePath != "" {
+		cache = loadCache(defaultCachePath)
+		defer func() {
+			if err := cache.save(); err != nil {
+				errorf("saving cache: %s", err)
+			}
+		}()
+	}
+
+	render := newRenderer(opt.renderer)
+	v := &viewer{
+		opt:         opt,
+		pics:        pics,
+		skipped:     skipped,
+		filter:      filter,
+		cache:       cache,
+		loader:      newLoader(opt.detect, cache),
+		render:      render,
+		prefetch:    newPrefetcher(render, opt.prefetchmem<<20),
+		dir:         1,
+		zoomed:      make(chan zoomResult),
+		stats:       make(chan statsResult),
+		hashes:      make(chan hashResult),
+		anims:       make(chan animResult),
+		diffs:       make(chan diffResult),
+		metas:       make(chan metaResult),
+		speed:       1,
+		rand:        rng,
+		seen:        make(map[*picture]bool),
+		statusDirty: true,
+	}
+
+	if cli.strict {
+		// Everything has to be checked before we can start.
+		v.loadFirst(len(v.pics))
+		if n := countRejected(v.skipped); n > 0 {
+			for _, s := range v.skipped {
+				if s.rejected {
+					fmt.Fprintf(os.Stderr, "spit: %s: %s\n", s.path, s.reason)
+				}
+			}
+			return fmt.Errorf("%d file(s) rejected", n)
+		}
+	}
+	// Files before the start image that turn out to be no images
+	// would shift it later on.
+	v.loadFirst(cli.startIdx)
+	// Watched directories may get images later on.
+	if len(v.pics) == 0 && !cli.watch {
+		return fmt.Errorf("no images loaded")
+	}
+	if v.curr, err = startIndex(v.pics, cli.startIdx, cli.startPath); err != nil {
 		warnp(err)
 	}
+	if cli.slideshow > 0 {
+		v.opt.slideshow = cli.slideshow
+		v.slideshow = newSlideshow(cli.slideshow)
+	}
+	if cli.diff {
+		if len(v.pics) != 2 {
+			return errors.New("-diff requires exactly two images")
+		}
+		v.diff = &diffView{a: v.pics[0], b: v.pics[1], dirty: true}
+	}
+	if cli.watch {
+		dirs := watchDirs(cli.args)
+		if cli.noArgs {
+			dirs = watchDirs([]string{"."})
+		}
+		if len(dirs) == 0 {
+			return errors.New("-watch requires a directory argument")
+		}
+		w, err := newWatcher(dirs)
+		if err != nil {
+			return err
+		}
+		v.watch = w.events
+	}
 
 	fdIn := int(os.Stdin.Fd())
-	fdOut := int(os.Stdout.Fd())
+	if !term.IsTerminal(fdIn) {
+		// Checking the files is all -strict can do without a terminal.
+		if cli.strict {
+			return nil
+		}
+		return errors.New("stdin is not a terminal (use -desktop when launching from a GUI)")
+	}
 
 	oldState, err := term.MakeRaw(fdIn)
 	if err != nil {
@@ -118,136 +279,73 @@ func run(cli flags) error {
 	hideCursor()
 	defer showCursor()
 
-	reader := bufio.NewReader(os.Stdin)
-	last := -1
-	for {
-		if last != curr {
-			last = curr
-			if opt.title {
-				setTitle("spit - " + pics[curr].name)
-			}
-
-			cols, rows, err := term.GetSize(fdOut)
-			if err != nil {
-				return err
-			}
-			pic := pics[curr]
-			path := pic.path
-
-			errMsg := ""
-			if err := execCmd(generateCmd(opt.cleaner, cols, rows, path)); err != nil {
-				errorf("cleaning screen: %s", err)
-				errMsg = "Error clearing screen"
-			}
-			moveCursor(1, 1)
-			if err := execCmd(generateCmd(opt.previewer, cols, rows, path)); err != nil {
-				errorf("displaying image: %s", err)
-				errMsg = fmt.Sprintf("Error displaying %q", path)
-			}
-			printStatus(opt, pic, curr+1, total, cols, rows)
-			if errMsg != "" {
-				showError(opt.errorfmt, errMsg, rows)
-			}
-		}
-		key, count, err := readKey(reader)
-		if err != nil {
-			return err
+	defer func() {
+		if err := v.extract.cleanup(); err != nil {
+			errorf("removing extracted files: %s", err)
 		}
-		switch key {
-		case 'q':
-			return nil
-		case 'l', 'j':
-			curr = move(curr, total, max(count, 1), opt.wrapscroll)
-		case 'h', 'k':
-			curr = move(curr, total, -max(count, 1), opt.wrapscroll)
-		case 'g':
-			// TODO: gg
-			curr = 0
-		case 'G':
-			// G jumps to the last image unless it is preceded by a count.
-			if count == 0 {
-				curr = total - 1
-			} else {
-				curr = min(count, total) - 1
-			}
-		case '?':
-			clear()
-			printAt(1, 1, usageLine)
-			for i, v := range strings.Split(helpMessage, "\n") {
-				printAt(2+i, 1, v)
-			}
-			printAt(999, 1, "Press any key to continue...")
-			readKey(reader)
-			clear()
-			last--
-		}
-	}
-}
+	}()
 
-func readKey(r *bufio.Reader) (byte, int, error) {
-	count := 0
-	for {
-		b, err := r.ReadByte()
-		if err != nil {
-			return 0, 0, err
-		}
-		if !isDigit(b) {
-			return b, count, nil
-		}
-		count = count*10 + int(b-'0')
-	}
+	return v.loop(newInput(os.Stdin))
 }
 
-func isDigit(b byte) bool {
-	return b >= '0' && b <= '9'
-}
-
-func pathsFromArgs(args, allowList []string) []string {
+// pathsFromArgs expands and filters args. Paths that were filtered out
+// are returned as skipped.
+func pathsFromArgs(args []string, pf *pathFilter) ([]string, []skippedFile) {
 	out := make([]string, 0, len(args))
+	var skipped []skippedFile
 
-	appendPath := func(p string) {
-		if len(allowList) == 0 ||
-			slices.Contains(allowList, strings.ToLower(filepath.Ext(p))) {
+	appendPath := func(p, reason string) {
+		// Archives are filtered by their entries instead.
+		if reason == "" && isArchive(p) {
+			out = append(out, p)
+			return
+		}
+		if reason == "" {
+			reason = pf.skipReason(p)
+		}
+		if reason == "" {
 			out = append(out, p)
+			return
 		}
-		// TODO: Log skipped images
+		debugf("skipping %s: %s", p, reason)
+		skipped = append(skipped, skippedFile{path: p, reason: reason})
 	}
 
 	for _, p := range args {
 		// Only expand literal directory arguments, not glob matches.
 		if !strings.HasSuffix(p, string(os.PathSeparator)) {
-			appendPath(p)
+			appendPath(p, "")
+			continue
+		}
+		// Entries are sorted by filename.
+		entries, err := os.ReadDir(p)
+		if err != nil {
+			skipped = append(skipped, rejectedFile(p, err))
 			continue
 		}
-		func() {
-			f, err := os.Open(p)
-			if err != nil {
-				return
-			}
-			defer f.Close()
-
-			names, err := f.Readdirnames(-1)
-			if err != nil {
-				return
-			}
 
-			for _, name := range names {
-				appendPath(filepath.Join(p, name))
+		ignored := pf.dirRules(p)
+		for _, e := range entries {
+			// Directories are not expanded recursively.
+			if e.IsDir() {
+				p := filepath.Join(p, e.Name())
+				reason := cmp.Or(pf.entryReason(e.Name(), ignored), "directory")
+				debugf("skipping %s: %s", p, reason)
+				skipped = append(skipped, skippedFile{path: p, reason: reason})
+				continue
 			}
-		}()
+			appendPath(filepath.Join(p, e.Name()), pf.entryReason(e.Name(), ignored))
+		}
 	}
 
-	return out
+	return out, skipped
 }
 
-func newPicture(path string) (*picture, error) {
-	f, err := os.Open(path)
-	if err != nil {
-		return nil, err
-	}
-	defer f.Close()
+// errNotImage is returned for files whose content is not recognized as an image.
+var errNotImage = errors.New("not an image")
 
-	info, err := f.Stat()
+func newPicture(path, detect string, cache *metaCache) (*picture, error) {
+	info, err := os.Stat(path)
 	if err != nil {
 		return nil, err
 	}
@@ -260,28 +358,130 @@ func newPicture(path string) (*picture, error) {
 		return nil, err
 	}
 
-	cfg, _, err := image.DecodeConfig(f)
+	// Open the file even if it is cached, so unreadable files are reported.
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return resolvePicture(f, info.Name(), absPath, info.Size(), info, detect, cache)
+}
+
+// resolvePicture returns the picture at path, reading its metadata
+// from r unless it is cached. info describes the file holding it,
+// which decides whether cache entries are still valid.
+func resolvePicture(r io.Reader, name, path string, size int64, info fs.FileInfo, detect string, cache *metaCache) (*picture, error) {
+	// Unrecognized files have to be looked at again in content mode.
+	if e, ok := cache.lookup(path, info); ok && (e.Format != "" || detect != "content") {
+		return &picture{
+			name:        name,
+			path:        path,
+			size:        size,
+			width:       e.Width,
+			height:      e.Height,
+			format:      e.Format,
+			orientation: e.Orientation,
+			taken:       e.Taken,
+			loaded:      true,
+		}, nil
+	}
+
+	img, err := probeImage(r, path, detect)
+	if err != nil {
+		return nil, err
+	}
+
+	pic := &picture{
+		name:        name,
+		path:        path,
+		size:        size,
+		width:       img.width,
+		height:      img.height,
+		format:      img.format,
+		orientation: img.orientation,
+		taken:       img.taken,
+		loaded:      true,
+	}
+	cache.store(pic, info)
+	return pic, nil
+}
+
+// imageInfo is what probeImage learns about an image.
+type imageInfo struct {
+	// width and height are the dimensions as displayed,
+	// i.e. with the orientation applied.
+	width, height int
+	format        string
+	orientation   int
+	// taken is the Exif DateTimeOriginal.
+	taken string
+}
+
+// probeImage determines format, dimensions and orientation of the image read from r.
+// path is used for error messages and to decide whether decoding errors
+// matter for unrecognized formats.
+func probeImage(r io.Reader, path, detect string) (imageInfo, error) {
+	br := bufio.NewReader(r)
+	header, _ := br.Peek(512)
+	sniffed, native := sniffFormat(header)
+
+	// Keep what DecodeConfig reads, so we can start over looking for
+	// the orientation without requiring r to be seekable.
+	var seen bytes.Buffer
+	cfg, format, err := image.DecodeConfig(io.TeeReader(br, &seen))
 	if err != nil {
+		format = sniffed
+		switch {
+		case native:
+			return imageInfo{}, &os.PathError{Op: "decoding", Path: path, Err: err}
+		case format != "":
+			debugf("recognized %s, skipping validation: %s", format, path)
+		case detect == "content":
+			return imageInfo{}, &os.PathError{Op: "detecting", Path: path, Err: errNotImage}
 		// DecodeConfig errors are only meaningful for known formats.
-		if slices.Contains(knownFormats, strings.ToLower(filepath.Ext(absPath))) {
-			return nil, &os.PathError{Op: "decoding", Path: path, Err: err}
-		} else {
+		case slices.Contains(knownFormats, strings.ToLower(filepath.Ext(path))):
+			return imageInfo{}, &os.PathError{Op: "decoding", Path: path, Err: err}
+		default:
 			debugf("skipping validation: %s", path)
 		}
+		return imageInfo{format: format}, nil
+	}
+
+	info := imageInfo{width: cfg.Width, height: cfg.Height, format: format}
+	exif := readExif(io.MultiReader(&seen, br), format)
+	info.orientation = tiffOrientation(exif)
+	info.taken = parseExif(exif)["DateTimeOriginal"]
+	if swapsAxes(info.orientation) {
+		info.width, info.height = info.height, info.width
+	}
+	return info, nil
+}
+
+// expandSiblings replaces a single file argument with its directory,
+// starting at that file. It returns the file, or "" if args were left alone.
+func expandSiblings(cli flags) (string, flags) {
+	if len(cli.args) != 1 {
+		return "", cli
+	}
+	p := cli.args[0]
+	if strings.HasSuffix(p, string(os.PathSeparator)) || isArchive(p) {
+		return "", cli
+	}
+	if info, err := os.Stat(p); err != nil || !info.Mode().IsRegular() {
+		return "", cli
 	}
 
-	return &picture{
-		name:   info.Name(),
-		path:   absPath,
-		size:   info.Size(),
-		width:  cfg.Width,
-		height: cfg.Height,
-	}, nil
+	p = filepath.Clean(p)
+	cli.args = []string{filepath.Dir(p) + string(os.PathSeparator)}
+	if cli.startIdx == 0 && cli.startPath == "" {
+		cli.startPath = p
+	}
+	return filepath.Join(filepath.Dir(p), filepath.Base(p)), cli
 }
 
 func startIndex(pics []*picture, startIdx int, startPath string) (int, error) {
 	if startIdx >= 1 {
-		startIdx = min(startIdx, len(pics)) - 1
+		startIdx = max(min(startIdx, len(pics))-1, 0)
 		return startIdx, nil
 	}
 	if startPath == "" {
@@ -295,16 +495,31 @@ func startIndex(pics []*picture, startIdx int, startPath string) (int, error) {
 	}
 	base := filepath.Base(path)
 	for i, p := range pics {
-		if p.path == path || p.name == base {
+		if p.path == path || filepath.Base(p.path) == base {
 			return i, nil
 		}
 	}
 	return 0, fmt.Errorf("start image not loaded: %s", startPath)
 }
 
+// preview holds the values expanded in previewer and cleaner commands.
+type preview struct {
+	path string
+	// orientation is the orientation to display the image in, combining
+	// the Exif orientation with userOrientation.
+	orientation, userOrientation int
+	// cols and rows are the size of the screen area, which starts at x and y.
+	cols, rows int
+	x, y       int
+	// cropX and cropY are the top left corner of the visible part
+	// of the image, shown at zoom percent.
+	cropX, cropY int
+	zoom         int
+}
+
 // generateCmd splits s by whitespace and expands its placeholders.
 // It returns the executable name and its arguments.
-func generateCmd(s string, cols, rows int, path string) (string, []string) {
+func generateCmd(s string, p preview) (string, []string) {
 	parts := strings.Fields(s)
 	if len(parts) == 0 {
 		return "", nil
@@ -312,9 +527,16 @@ func generateCmd(s string, cols, rows int, path string) (string, []string) {
 
 	r := strings.NewReplacer(
 		"%%", "%",
-		"%c", strconv.Itoa(cols),
-		"%r", strconv.Itoa(max(rows-2, 0)),
-		"%f", path,
+		"%c", strconv.Itoa(p.cols),
+		"%r", strconv.Itoa(max(p.rows-2, 0)),
+		"%X", strconv.Itoa(p.x),
+		"%Y", strconv.Itoa(p.y),
+		"%x", strconv.Itoa(p.cropX),
+		"%y", strconv.Itoa(p.cropY),
+		"%z", strconv.Itoa(p.zoom),
+		"%o", strconv.Itoa(max(p.orientation, orientNormal)),
+		"%t", strconv.Itoa(max(p.userOrientation, orientNormal)),
+		"%f", p.path,
 	)
 
 	for i, v := range parts {
@@ -353,70 +575,6 @@ func move(idx, n, delta int, wrap bool) int {
 	return min(max(next, 0), n-1)
 }
 
-func printStatus(opt options, pic *picture, idx, total, cols, rows int) {
-	if opt.statusline == "" {
-		return
-	}
-
-	var size string
-	if opt.humanreadable {
-		size = fmt.Sprintf("%5s", humanReadable(pic.size))
-	} else {
-		size = fmt.Sprintf("%dB", pic.size)
-	}
-
-	r := strings.NewReplacer(
-		"%%", "%",
-		"%f", pic.name,
-		"%h", strconv.Itoa(pic.height),
-		"%i", strconv.Itoa(idx),
-		"%s", size,
-		"%t", strconv.Itoa(total),
-		"%w", strconv.Itoa(pic.width),
-	)
-	s := r.Replace(opt.statusline)
-	if pic.height == 0 && pic.width == 0 {
-		s = strings.ReplaceAll(strings.ReplaceAll(s, "0x0", "N/A"), "0X0", "N/A")
-	}
-
-	gaps := strings.Count(opt.statusline, "%=")
-	excess := (displayWidth(s) - gaps*2) - cols // account for %=
-	if excess > 0 {
-		// try truncating filename if possible
-		if excess < displayWidth(pic.name) {
-			// use runes for slicing to not mess up multi-byte chars
-			repl := opt.truncatechar + string([]rune(pic.name)[excess+displayWidth(opt.truncatechar):])
-			s = strings.Replace(s, pic.name, repl, 1)
-		} else {
-			// if still too long, truncate entire string from the left
-			s = opt.truncatechar + string([]rune(s)[excess+displayWidth(opt.truncatechar):])
-		}
-	}
-
-	free := max(cols-(displayWidth(s)-gaps*2), 0)
-	gapSize, rem := 0, 0
-	if gaps > 0 {
-		gapSize = free / gaps
-		rem = free % gaps
-	}
-
-	parts := strings.Split(s, "%=")
-	var b strings.Builder
-	b.WriteString(parts[0])
-	for i, p := range parts[1:] {
-		spaces := gapSize
-		if i < rem {
-			spaces++
-		}
-		b.WriteString(strings.Repeat(" ", spaces))
-		b.WriteString(p)
-	}
-
-	moveCursor(rows, 1)
-	clearLine()
-	printAt(rows, 1, b.String())
-}
-
 func humanReadable(size int64) string {
 	if size < 1024 {
 		return fmt.Sprintf("%dB", size)
@@ -0,0 +1,587 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/xml"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxMetadataSize bounds the size of a single metadata block,
+// like a PNG text chunk, including after decompression.
+const maxMetadataSize = 8 << 20
+
+// metadata holds the metadata embedded in a picture by namespace,
+// each mapping field names to values formatted for display.
+type metadata struct {
+	exif map[string]string
+	// xmp is keyed by the local name of the properties, e.g. "Rating".
+	xmp  map[string]string
+	iptc map[string]string
+	// text holds the text chunks of PNG files by keyword.
+	text map[string]string
+}
+
+// Metadata namespaces as used in the statusline.
+const (
+	metaExif = "exif"
+	metaXMP  = "xmp"
+	metaIPTC = "iptc"
+	metaPNG  = "png"
+)
+
+func newMetadata() *metadata {
+	return &metadata{
+		exif: make(map[string]string),
+		xmp:  make(map[string]string),
+		iptc: make(map[string]string),
+		text: make(map[string]string),
+	}
+}
+
+// lookup returns the field name of namespace ns. Names are matched
+// ignoring case if there is no exact match. Nil metadata has no fields.
+func (m *metadata) lookup(ns, name string) string {
+	if m == nil {
+		return ""
+	}
+	var fields map[string]string
+	switch strings.ToLower(ns) {
+	case metaExif:
+		fields = m.exif
+	case metaXMP:
+		fields = m.xmp
+	case metaIPTC:
+		fields = m.iptc
+	case metaPNG:
+		fields = m.text
+	}
+	if v, ok := fields[name]; ok {
+		return v
+	}
+	for k, v := range fields {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return ""
+}
+
+// loadMetadata returns the metadata of p, reading it on first use.
+// Pictures not loaded yet have none.
+func (p *picture) loadMetadata() *metadata {
+	if p.meta != nil {
+		return p.meta
+	}
+	if !p.loaded {
+		return newMetadata()
+	}
+	p.meta = readPictureMetadata(p)
+	return p.meta
+}
+
+// readPictureMetadata reads the metadata of p. Unreadable files have none.
+func readPictureMetadata(p *picture) *metadata {
+	m := newMetadata()
+	rc, err := p.open()
+	if err != nil {
+		errorf("reading metadata: %s", err)
+		return m
+	}
+	defer rc.Close()
+	readMetadata(rc, p.format, m)
+	return m
+}
+
+// metaResult carries metadata read in the background.
+type metaResult struct {
+	pic  *picture
+	meta *metadata
+}
+
+// requestMetadata returns the metadata of pic if it has been read,
+// or nil while it is being read in the background. Only one picture is
+// read at a time, later requests are repeated once it is done.
+func (v *viewer) requestMetadata(pic *picture) *metadata {
+	if pic.meta != nil || !pic.loaded || v.metaPending != nil {
+		return pic.meta
+	}
+	v.metaPending = pic
+	go func() {
+		v.metas <- metaResult{pic: pic, meta: readPictureMetadata(pic)}
+	}()
+	return nil
+}
+
+// handleMetadata stores metadata read in the background.
+func (v *viewer) handleMetadata(res metaResult) {
+	v.metaPending = nil
+	// Overlays may have read it meanwhile.
+	if res.pic.meta == nil && res.pic.loaded {
+		res.pic.meta = res.meta
+	}
+	v.statusDirty = true
+}
+
+// readMetadata adds the metadata of the image read from r to m.
+// r has to be positioned at the start of the file.
+func readMetadata(r io.Reader, format string, m *metadata) {
+	switch format {
+	case "jpeg":
+		jpegSegments(r, func(marker byte, seg []byte) bool {
+			switch {
+			case marker == 0xe1 && bytes.HasPrefix(seg, []byte("Exif\x00\x00")):
+				addFields(m.exif, parseExif(seg[6:]))
+			case marker == 0xe1 && bytes.HasPrefix(seg, []byte(xmpPrefix)):
+				addFields(m.xmp, parseXMP(seg[len(xmpPrefix):]))
+			case marker == 0xed:
+				addFields(m.iptc, parseIPTC(photoshopIPTC(seg)))
+			}
+			return true
+		})
+	case "png":
+		pngChunks(r, func(typ string, data []byte) bool {
+			switch typ {
+			case "eXIf":
+				addFields(m.exif, parseExif(data))
+			case "tEXt", "zTXt", "iTXt":
+				if k, v, ok := pngTextChunk(typ, data); ok {
+					if k == "XML:com.adobe.xmp" {
+						addFields(m.xmp, parseXMP([]byte(v)))
+					} else {
+						m.text[cleanText(k)] = cleanText(v)
+					}
+				}
+			case "IEND":
+				return false
+			}
+			return true
+		})
+	case "webp":
+		extended := false
+		webpChunks(r, func(fourcc string, data []byte) bool {
+			switch fourcc {
+			case "VP8X":
+				// Flags for XMP and Exif.
+				extended = true
+				return len(data) > 0 && data[0]&0x0c != 0
+			case "VP8 ", "VP8L":
+				// Simple files can't hold metadata.
+				return extended
+			case "EXIF":
+				data, _ = bytes.CutPrefix(data, []byte("Exif\x00\x00"))
+				addFields(m.exif, parseExif(data))
+			case "XMP ":
+				addFields(m.xmp, parseXMP(data))
+			}
+			return true
+		})
+	case "tiff":
+		data := tiffHead(r)
+		addFields(m.exif, parseExif(data))
+		addFields(m.xmp, parseXMP(tiffBlock(data, tagXMP)))
+		addFields(m.iptc, parseIPTC(tiffBlock(data, tagIPTC)))
+	}
+}
+
+// cleanText removes control characters from s, keeping line breaks and
+// tabs. Metadata comes from untrusted files and must not be able to send
+// escape sequences to the terminal.
+func cleanText(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r != '\n' && r != '\t' && unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// addFields adds the fields of src to dst, keeping existing ones.
+func addFields(dst, src map[string]string) {
+	for k, v := range src {
+		if _, ok := dst[k]; !ok {
+			dst[k] = v
+		}
+	}
+}
+
+// jpegSegments calls fn with the marker and payload of every segment
+// before the image data, until fn returns false.
+func jpegSegments(r io.Reader, fn func(marker byte, seg []byte) bool) {
+	br := bufioReader(r)
+	if _, err := br.Discard(2); err != nil { // SOI
+		return
+	}
+	for {
+		b, err := br.ReadByte()
+		if err != nil || b != 0xff {
+			return
+		}
+		marker, err := br.ReadByte()
+		for err == nil && marker == 0xff { // fill bytes
+			marker, err = br.ReadByte()
+		}
+		switch {
+		case err != nil, marker == 0xda, marker == 0xd9: // SOS, EOI
+			return
+		case marker >= 0xd0 && marker <= 0xd7, marker == 0x01: // no payload
+			continue
+		}
+		var n uint16
+		if binary.Read(br, binary.BigEndian, &n) != nil || n < 2 {
+			return
+		}
+		seg := make([]byte, n-2)
+		if _, err := io.ReadFull(br, seg); err != nil {
+			return
+		}
+		if !fn(marker, seg) {
+			return
+		}
+	}
+}
+
+// pngChunks calls fn with the type and data of every chunk, until fn
+// returns false. The data of image chunks and of chunks larger than
+// maxMetadataSize is skipped and passed as nil.
+func pngChunks(r io.Reader, fn func(typ string, data []byte) bool) {
+	br := bufioReader(r)
+	if _, err := br.Discard(8); err != nil { // signature
+		return
+	}
+	for {
+		var hdr [8]byte
+		if _, err := io.ReadFull(br, hdr[:]); err != nil {
+			return
+		}
+		n, typ := binary.BigEndian.Uint32(hdr[:]), string(hdr[4:])
+		var data []byte
+		if typ != "IDAT" && typ != "fdAT" && n <= maxMetadataSize {
+			data = make([]byte, n)
+			if _, err := io.ReadFull(br, data); err != nil {
+				return
+			}
+			n = 0
+		}
+		if !fn(typ, data) {
+			return
+		}
+		if _, err := io.CopyN(io.Discard, br, int64(n)+4); err != nil { // data and CRC
+			return
+		}
+	}
+}
+
+// webpChunks calls fn with the FourCC and data of every chunk, until fn
+// returns false. The data of image chunks and of chunks larger than
+// maxMetadataSize is skipped and passed as nil.
+func webpChunks(r io.Reader, fn func(fourcc string, data []byte) bool) {
+	var hdr [12]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return
+	}
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(r, chunk[:]); err != nil {
+			return
+		}
+		n, fourcc := binary.LittleEndian.Uint32(chunk[4:]), string(chunk[:4])
+		n += n & 1
+		var data []byte
+		frame := fourcc == "VP8 " || fourcc == "VP8L" || fourcc == "ANMF" || fourcc == "ALPH"
+		if !frame && n <= maxMetadataSize {
+			data = make([]byte, n)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return
+			}
+			n = 0
+		}
+		if !fn(fourcc, data) {
+			return
+		}
+		if _, err := io.CopyN(io.Discard, r, int64(n)); err != nil {
+			return
+		}
+	}
+}
+
+// pngTextChunk returns the keyword and text of a tEXt, zTXt or iTXt chunk.
+func pngTextChunk(typ string, data []byte) (string, string, bool) {
+	k, rest, ok := bytes.Cut(data, []byte{0})
+	if !ok || len(k) == 0 {
+		return "", "", false
+	}
+	key := latin1(k)
+	switch typ {
+	case "tEXt":
+		return key, latin1(rest), true
+	case "zTXt":
+		if len(rest) < 1 || rest[0] != 0 { // compression method
+			return "", "", false
+		}
+		text, err := inflate(rest[1:])
+		return key, latin1(text), err == nil
+	case "iTXt":
+		if len(rest) < 2 {
+			return "", "", false
+		}
+		compressed := rest[0] == 1
+		// Skip the language tag and the translated keyword.
+		_, rest, ok1 := bytes.Cut(rest[2:], []byte{0})
+		_, text, ok2 := bytes.Cut(rest, []byte{0})
+		if !ok1 || !ok2 {
+			return "", "", false
+		}
+		if compressed {
+			var err error
+			if text, err = inflate(text); err != nil {
+				return "", "", false
+			}
+		}
+		return key, strings.ToValidUTF8(string(text), "�"), true
+	}
+	return "", "", false
+}
+
+// inflate decompresses the zlib stream b, up to maxMetadataSize.
+func inflate(b []byte) ([]byte, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(io.LimitReader(zr, maxMetadataSize))
+}
+
+// latin1 decodes the ISO 8859-1 text b.
+func latin1(b []byte) string {
+	r := make([]rune, len(b))
+	for i, c := range b {
+		r[i] = rune(c)
+	}
+	return string(r)
+}
+
+// Tags of TIFF files holding XMP and IPTC blocks.
+const (
+	tagXMP  = 0x02bc
+	tagIPTC = 0x83bb
+)
+
+// tiffBlock returns the data of tag in the first IFD of the TIFF
+// structure b, or nil if it has none.
+func tiffBlock(b []byte, tag uint16) []byte {
+	bo := tiffByteOrder(b)
+	if bo == nil {
+		return nil
+	}
+	off := uint64(bo.Uint32(b[4:]))
+	if off+2 > uint64(len(b)) {
+		return nil
+	}
+	n := uint64(bo.Uint16(b[off:]))
+	for i := range n {
+		e := off + 2 + 12*i
+		if e+12 > uint64(len(b)) {
+			break
+		}
+		if bo.Uint16(b[e:]) != tag {
+			continue
+		}
+		size, ok := typeSizes[bo.Uint16(b[e+2:])]
+		if !ok {
+			return nil
+		}
+		end := uint64(size) * uint64(bo.Uint32(b[e+4:]))
+		if end <= 4 {
+			return b[e+8 : e+8+end]
+		}
+		pos := uint64(bo.Uint32(b[e+8:]))
+		if pos+end > uint64(len(b)) {
+			return nil
+		}
+		return b[pos : pos+end]
+	}
+	return nil
+}
+
+// xmpPrefix starts JPEG APP1 segments holding XMP.
+const xmpPrefix = "http://ns.adobe.com/xap/1.0/\x00"
+
+// parseXMP returns the simple properties of the XMP packet b by their
+// local name. Values of arrays, like keywords, are joined by commas.
+func parseXMP(b []byte) map[string]string {
+	fields := make(map[string]string)
+	d := xml.NewDecoder(bytes.NewReader(b))
+	d.Strict = false
+	var (
+		// names holds the open elements.
+		names []xml.Name
+		// prop is the property read and depth the level it starts at.
+		prop  string
+		depth int
+		vals  []string
+	)
+	isDescription := func(n xml.Name) bool {
+		return n.Space == "rdf" && n.Local == "Description"
+	}
+	for {
+		tok, err := d.RawToken()
+		if err != nil {
+			return fields
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch {
+			case prop != "":
+			case isDescription(t.Name):
+				// Simple properties are often written as attributes.
+				for _, a := range t.Attr {
+					switch a.Name.Space {
+					case "", "xmlns", "xml", "rdf":
+					default:
+						if v := strings.TrimSpace(a.Value); v != "" {
+							fields[a.Name.Local] = cleanText(v)
+						}
+					}
+				}
+			case len(names) > 0 && isDescription(names[len(names)-1]):
+				prop, depth, vals = t.Name.Local, len(names), nil
+			}
+			names = append(names, t.Name)
+		case xml.CharData:
+			if v := strings.TrimSpace(string(t)); prop != "" && v != "" {
+				vals = append(vals, v)
+			}
+		case xml.EndElement:
+			if len(names) == 0 {
+				return fields
+			}
+			names = names[:len(names)-1]
+			if prop != "" && len(names) == depth {
+				if len(vals) > 0 {
+					fields[prop] = cleanText(strings.Join(vals, ", "))
+				}
+				prop = ""
+			}
+		}
+	}
+}
+
+// photoshopIPTC returns the IPTC block stored in the Photoshop image
+// resources of a JPEG APP13 segment, or nil if it has none.
+func photoshopIPTC(seg []byte) []byte {
+	b, ok := bytes.CutPrefix(seg, []byte("Photoshop 3.0\x00"))
+	if !ok {
+		return nil
+	}
+	for len(b) >= 12 && string(b[:4]) == "8BIM" {
+		id := binary.BigEndian.Uint16(b[4:])
+		// The name is a Pascal string padded to an even size.
+		name := 1 + int(b[6])
+		name += name & 1
+		if 6+name+4 > len(b) {
+			return nil
+		}
+		n := int(binary.BigEndian.Uint32(b[6+name:]))
+		data := b[6+name+4:]
+		if n > len(data) {
+			return nil
+		}
+		if id == 0x0404 {
+			return data[:n]
+		}
+		b = data[min(n+n&1, len(data)):]
+	}
+	return nil
+}
+
+// iptcTags names the datasets of the application record (2) worth
+// showing, following ExifTool.
+var iptcTags = map[byte]string{
+	5:   "ObjectName",
+	15:  "Category",
+	25:  "Keywords",
+	40:  "SpecialInstructions",
+	55:  "DateCreated",
+	60:  "TimeCreated",
+	80:  "By-line",
+	85:  "By-lineTitle",
+	90:  "City",
+	92:  "Sub-location",
+	95:  "Province-State",
+	101: "Country-PrimaryLocationName",
+	105: "Headline",
+	110: "Credit",
+	115: "Source",
+	116: "CopyrightNotice",
+	120: "Caption-Abstract",
+	122: "Writer-Editor",
+}
+
+// parseIPTC returns the known datasets of the IPTC block b by name.
+// Repeated datasets, like keywords, are joined by commas.
+func parseIPTC(b []byte) map[string]string {
+	fields := make(map[string]string)
+	for len(b) >= 5 && b[0] == 0x1c {
+		rec, ds, n := b[1], b[2], int(binary.BigEndian.Uint16(b[3:]))
+		// Extended sizes are only used for large binary data.
+		if n&0x8000 != 0 || 5+n > len(b) {
+			break
+		}
+		val := b[5 : 5+n]
+		b = b[5+n:]
+		name, ok := iptcTags[ds]
+		if rec != 2 || !ok {
+			continue
+		}
+		// Older files use Latin-1 unless they declare UTF-8.
+		s := string(val)
+		if !utf8.Valid(val) {
+			s = latin1(val)
+		}
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		if prev, ok := fields[name]; ok {
+			s = prev + ", " + s
+		}
+		fields[name] = cleanText(s)
+	}
+	return fields
+}
+
+// expandMetadata replaces %{namespace:field} in s with the value from m,
+// which may be nil while unknown. Values are escaped for the other
+// expansions of the statusline.
+func expandMetadata(s string, m *metadata) string {
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(s, '%')
+		if i < 0 || i+1 == len(s) {
+			b.WriteString(s)
+			return b.String()
+		}
+		b.WriteString(s[:i])
+		s = s[i:]
+		if s[1] == '{' {
+			if field, rest, ok := strings.Cut(s[2:], "}"); ok {
+				if ns, name, ok := strings.Cut(field, ":"); ok {
+					v := m.lookup(ns, name)
+					// Texts like descriptions may span several lines.
+					v = strings.Join(strings.Fields(v), " ")
+					b.WriteString(strings.ReplaceAll(v, "%", "%%"))
+					s = rest
+					continue
+				}
+			}
+		}
+		// Leave other expansions, including %%, alone.
+		b.WriteString(s[:2])
+		s = s[2:]
+	}
+}
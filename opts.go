@@ -7,28 +7,65 @@ import (
 	"reflect"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type options struct {
-	cleaner       string   `comment:"Command used to cleanup the preview.\nFor more details about expansions, see 'previewer'."`
-	errorfmt      string   `comment:"Format string for error messages"`
-	extensions    []string `comment:"File extensions used to filter input paths.\nEmpty disables extension filtering."`
-	humanreadable bool     `comment:"Use human readable sizes"`
-	previewer     string   `comment:"Command used to preview images.\nFollowing expansions are available:\n%c terminal columns\n%r terminal rows\n%f file name (including path)"`
-	statusline    string   `comment:"Set the look of the statusline.\nFollowing expansions are available:\n%f file name\n%h image height\n%w image width\n%i current index\n%t total amount of images\n%s image size\n%= alignment separator"`
-	title         bool     `comment:"Whether to set the terminal title to the current image"`
-	truncatechar  string   `comment:"Character used for truncating the statusline when it gets too long"`
-	wrapscroll    bool     `comment:"Scroll past the last image back to the first one and vice versa"`
+	autojump      bool          `comment:"Jump to images added while watching directories"`
+	cleaner       string        `comment:"Command used to cleanup the preview.\nFor more details about expansions, see 'previewer'."`
+	detect        string        `comment:"How to recognize images:\nextension  only load files listed in 'extensions'\ncontent    sniff file contents, ignoring extensions"`
+	errorfmt      string        `comment:"Format string for error messages"`
+	exclude       []string      `comment:"Gitignore-style patterns of paths to skip.\nPatterns without a slash match at any level (e.g. '@eaDir' or '*.thumb.jpg')."`
+	extensions    []string      `comment:"File extensions used to filter input paths.\nEmpty disables extension filtering.\nIgnored when 'detect' is set to content."`
+	hidden        bool          `comment:"Show hidden files when expanding directories and wildcards"`
+	humanreadable bool          `comment:"Use human readable sizes"`
+	ignorefiles   []string      `comment:"Ignore files (e.g. '.gitignore,.ignore') respected when expanding directories"`
+	include       []string      `comment:"Gitignore-style patterns of paths to load.\nEmpty includes everything."`
+	prefetch      int           `comment:"Number of images ahead of and behind the current one to prepare in advance.\nImages in the direction of navigation come first."`
+	prefetchmem   int           `comment:"Memory in MiB used to keep prepared images for built-in renderers"`
+	preload       int           `comment:"Number of images before and after the current one to load metadata for.\nEverything else is loaded in the background afterwards."`
+	previewer     string        `comment:"Command used to preview images.\nFollowing expansions are available:\n%c columns available to the image\n%r rows available to the image\n%X column the image starts at (0-based)\n%Y row the image starts at (0-based)\n%x column of the image shown at the top left (0-based, in image pixels)\n%y row of the image shown at the top left (0-based, in image pixels)\n%z zoom in percent of the image size\n%o orientation to display the image in (Exif orientation combined with %t)\n%t rotations and flips done while viewing, as Exif orientation (1-8)\n%f file name (including path)\nImages inside archives are extracted to a temporary file first."`
+	renderer      string        `comment:"Draw images without running the previewer:\nkitty   kitty graphics protocol\nblocks  Unicode half blocks and 24-bit colors\nEmpty uses 'previewer' and 'cleaner'."`
+	shuffle       bool          `comment:"Show images in random order during slideshows"`
+	siblings      bool          `comment:"When started with a single file, load all images of its directory"`
+	slideshow     time.Duration `comment:"Time each image is shown during slideshows"`
+	sort          string        `comment:"Order of the images:\nnone    as given, directories sorted by name\nrandom  shuffled (reproducible using -seed, the seed is logged)"`
+	spread        string        `comment:"Show two pages side by side, like a book:\nnone  one image at a time\nltr   left-to-right reading order\nrtl   right-to-left reading order (manga)\nThe previewer runs once per page, so clearing belongs in 'cleaner'."`
+	spreadcover   bool          `comment:"Show the first image on its own in spread mode"`
+	statusline    string        `comment:"Set the look of the statusline.\nFollowing expansions are available:\n%a archive name (empty outside of archives)\n%f file name (path inside archives)\n%F image format\n%h image height\n%w image width\n%z zoom level (empty outside of zoom and compare mode)\n%i current index\n%k number of skipped files (empty if none)\n%l loading indicator (empty when done)\n%m number of marked images (empty if none)\n%n animation frame, speed and state (empty unless animated)\n%t total amount of images\n%s image size\n%S slideshow countdown (empty unless running)\n%{exif:Model} metadata field, also xmp, iptc and png (PNG text)\n%= alignment separator\nWords expanding to nothing are left out along with the space before them."`
+	terminal      string        `comment:"Terminal emulator used by -desktop when not started from a terminal.\nspit and its arguments are appended to the command."`
+	thumbsize     int           `comment:"Width of the tiles in gallery mode, in columns (at least 4)"`
+	title         bool          `comment:"Whether to set the terminal title to the current image"`
+	truncatechar  string        `comment:"Character used for truncating the statusline when it gets too long"`
+	wrapscroll    bool          `comment:"Scroll past the last image back to the first one and vice versa"`
 }
 
 func defaultConfig() options {
 	return options{
+		autojump:      false,
 		cleaner:       "",
+		detect:        "extension",
 		errorfmt:      "\033[7;31;47m",
+		exclude:       nil,
 		extensions:    knownFormats,
+		hidden:        false,
 		humanreadable: false,
+		ignorefiles:   nil,
+		include:       nil,
+		prefetch:      2,
+		prefetchmem:   256,
+		preload:       20,
 		previewer:     "kitten icat --clear --stdin=no --transfer-mode=memory --place=%cx%r@0x0 --scale-up=yes %f",
-		statusline:    "%f %= %wx%h  %s  %i/%t",
+		renderer:      "",
+		shuffle:       false,
+		siblings:      false,
+		slideshow:     5 * time.Second,
+		sort:          sortNone,
+		spread:        spreadNone,
+		spreadcover:   true,
+		statusline:    "%f %= %l  %k  %m  %S  %n  %z  %wx%h  %s  %i/%t",
+		terminal:      "kitty",
+		thumbsize:     16,
 		title:         false,
 		truncatechar:  "<",
 		wrapscroll:    true,
@@ -59,11 +96,18 @@ func (o options) String() string {
 		switch val.Kind() {
 		case reflect.Bool:
 			b.WriteString(strconv.FormatBool(val.Bool()))
+		case reflect.Int:
+			b.WriteString(strconv.Itoa(int(val.Int())))
+		case reflect.Int64: // time.Duration
+			b.WriteString(time.Duration(val.Int()).String())
 		case reflect.Slice:
 			parts := make([]string, val.Len())
 			for j := range parts {
-				// remove dots from extensions
-				parts[j] = strings.TrimPrefix(val.Index(j).String(), ".")
+				parts[j] = val.Index(j).String()
+				if field.Name == "extensions" {
+					// remove dots from extensions
+					parts[j] = strings.TrimPrefix(parts[j], ".")
+				}
 			}
 			b.WriteString(strconv.Quote(strings.Join(parts, ",")))
 		default:
@@ -80,32 +124,116 @@ func (o options) String() string {
 
 func (o *options) update(key, val string) error {
 	switch key {
+	case "autojump":
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			return fmt.Errorf("invalid value for autojump: %w", err)
+		}
+		o.autojump = b
 	case "cleaner":
 		o.cleaner = val
+	case "detect":
+		if val != "extension" && val != "content" {
+			return fmt.Errorf("invalid value for detect: %s", val)
+		}
+		o.detect = val
 	case "errorfmt":
 		o.errorfmt = val
+	case "exclude":
+		o.exclude = splitList(val)
 	case "extensions":
-		items := strings.Split(val, ",")
-		var exts []string
-		for _, it := range items {
-			if e := strings.TrimSpace(it); e != "" {
-				if !strings.HasPrefix(e, ".") {
-					e = "." + e
-				}
-				exts = append(exts, e)
+		exts := splitList(val)
+		for i, e := range exts {
+			if !strings.HasPrefix(e, ".") {
+				exts[i] = "." + e
 			}
 		}
 		o.extensions = exts
+	case "hidden":
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			return fmt.Errorf("invalid value for hidden: %w", err)
+		}
+		o.hidden = b
 	case "humanreadable":
 		b, err := strconv.ParseBool(val)
 		if err != nil {
 			return fmt.Errorf("invalid value for humanreadable: %w", err)
 		}
 		o.humanreadable = b
+	case "ignorefiles":
+		o.ignorefiles = splitList(val)
+	case "include":
+		o.include = splitList(val)
+	case "prefetch":
+		n, err := strconv.Atoi(val)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid value for prefetch: %s", val)
+		}
+		o.prefetch = n
+	case "prefetchmem":
+		n, err := strconv.Atoi(val)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid value for prefetchmem: %s", val)
+		}
+		o.prefetchmem = n
+	case "preload":
+		n, err := strconv.Atoi(val)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid value for preload: %s", val)
+		}
+		o.preload = n
 	case "previewer":
 		o.previewer = val
+	case "renderer":
+		if val != "" && val != rendererKitty && val != rendererBlocks {
+			return fmt.Errorf("invalid value for renderer: %s", val)
+		}
+		o.renderer = val
+	case "shuffle":
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			return fmt.Errorf("invalid value for shuffle: %w", err)
+		}
+		o.shuffle = b
+	case "siblings":
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			return fmt.Errorf("invalid value for siblings: %w", err)
+		}
+		o.siblings = b
+	case "slideshow":
+		d, err := time.ParseDuration(val)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid value for slideshow: %s", val)
+		}
+		o.slideshow = d
+	case "sort":
+		if val != sortNone && val != sortRandom {
+			return fmt.Errorf("invalid value for sort: %s", val)
+		}
+		o.sort = val
+	case "spread":
+		if val != spreadNone && val != spreadLTR && val != spreadRTL {
+			return fmt.Errorf("invalid value for spread: %s", val)
+		}
+		o.spread = val
+	case "spreadcover":
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			return fmt.Errorf("invalid value for spreadcover: %w", err)
+		}
+		o.spreadcover = b
 	case "statusline":
 		o.statusline = val
+	case "terminal":
+		o.terminal = val
+	case "thumbsize":
+		n, err := strconv.Atoi(val)
+		if err != nil || n < 4 {
+			return fmt.Errorf("invalid value for thumbsize: %s", val)
+		}
+		o.thumbsize = n
 	case "title":
 		b, err := strconv.ParseBool(val)
 		if err != nil {
@@ -130,6 +258,17 @@ func (o *options) update(key, val string) error {
 	return nil
 }
 
+// splitList splits a comma separated list, dropping empty items.
+func splitList(val string) []string {
+	var out []string
+	for it := range strings.SplitSeq(val, ",") {
+		if it = strings.TrimSpace(it); it != "" {
+			out = append(out, it)
+		}
+	}
+	return out
+}
+
 func loadConfig(path string) (options, error) {
 	opt := defaultConfig()
 
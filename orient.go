@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/draw"
+	"io"
+)
+
+// Exif orientations, describing how the stored image has to be
+// transformed for display.
+const (
+	orientNormal     = 1
+	orientFlipH      = 2
+	orientRotate180  = 3
+	orientFlipV      = 4
+	orientTranspose  = 5
+	orientRotate90   = 6 // clockwise
+	orientTransverse = 7
+	orientRotate270  = 8 // clockwise
+)
+
+// maxExifSize bounds the amount of data read looking for Exif blocks
+// that are not limited by their container already.
+const maxExifSize = 1 << 20
+
+// swapsAxes reports whether orientation o turns width into height.
+func swapsAxes(o int) bool {
+	return o >= orientTranspose
+}
+
+// Exif tag and type holding the orientation.
+const (
+	tagOrientation = 0x0112
+	typeShort      = 3
+)
+
+// tiffOrientation returns the orientation tag of the first IFD in the
+// TIFF structure b, which is what Exif blocks consist of.
+func tiffOrientation(b []byte) int {
+	bo, pos := orientationEntry(b)
+	if pos < 0 || bo.Uint16(b[pos+2:]) != typeShort {
+		return 0
+	}
+	if o := int(bo.Uint16(b[pos+8:])); o >= orientNormal && o <= orientRotate270 {
+		return o
+	}
+	return 0
+}
+
+// orientationEntry returns the byte order of the TIFF structure b and the
+// offset of the orientation entry in its first IFD, or -1 if there is none.
+func orientationEntry(b []byte) (binary.ByteOrder, int) {
+	bo := tiffByteOrder(b)
+	if bo == nil {
+		return nil, -1
+	}
+	off := uint64(bo.Uint32(b[4:]))
+	if off+2 > uint64(len(b)) {
+		return nil, -1
+	}
+	n := uint64(bo.Uint16(b[off:]))
+	for i := range n {
+		e := off + 2 + 12*i
+		if e+12 > uint64(len(b)) {
+			return nil, -1
+		}
+		if bo.Uint16(b[e:]) == tagOrientation {
+			return bo, int(e)
+		}
+	}
+	return nil, -1
+}
+
+// tiffByteOrder returns the byte order of the TIFF structure b,
+// or nil if b is none.
+func tiffByteOrder(b []byte) binary.ByteOrder {
+	if len(b) < 8 {
+		return nil
+	}
+	switch string(b[:2]) {
+	case "II":
+		return binary.LittleEndian
+	case "MM":
+		return binary.BigEndian
+	}
+	return nil
+}
+
+// tiffHead returns the start of the TIFF file read from r, including its
+// first IFD. Usually that is found at the start, but it is moved to the end
+// when adding an orientation. If so, it is returned right after the header,
+// without the values stored elsewhere.
+func tiffHead(r io.Reader) []byte {
+	br := bufioReader(r)
+	data, _ := io.ReadAll(io.LimitReader(br, maxExifSize))
+	bo := tiffByteOrder(data)
+	if bo == nil || len(data) < maxExifSize {
+		return data
+	}
+	off := int64(bo.Uint32(data[4:]))
+	if off+2 <= int64(len(data)) {
+		return data
+	}
+	if _, err := br.Discard(int(off) - len(data)); err != nil {
+		return data
+	}
+	var count [2]byte
+	if _, err := io.ReadFull(br, count[:]); err != nil {
+		return data
+	}
+	head := append(bytes.Clone(data[:8]), count[:]...)
+	bo.PutUint32(head[4:], 8)
+	ifd := make([]byte, 12*int(bo.Uint16(count[:]))+4)
+	if _, err := io.ReadFull(br, ifd); err != nil {
+		return data
+	}
+	return append(head, ifd...)
+}
+
+// bufioReader returns r as a *bufio.Reader, wrapping it if necessary.
+func bufioReader(r io.Reader) *bufio.Reader {
+	if br, ok := r.(*bufio.Reader); ok {
+		return br
+	}
+	return bufio.NewReader(r)
+}
+
+// orient transforms img for display according to orientation o.
+func orient(img image.Image, o int) image.Image {
+	if o <= orientNormal || o > orientRotate270 {
+		return img
+	}
+	b := img.Bounds()
+	src, ok := img.(*image.RGBA)
+	if !ok || b.Min != (image.Point{}) {
+		src = image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	}
+	sw, sh := b.Dx(), b.Dy()
+	dw, dh := sw, sh
+	if swapsAxes(o) {
+		dw, dh = sh, sw
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := range dh {
+		for x := range dw {
+			var sx, sy int
+			switch o {
+			case orientFlipH:
+				sx, sy = sw-1-x, y
+			case orientRotate180:
+				sx, sy = sw-1-x, sh-1-y
+			case orientFlipV:
+				sx, sy = x, sh-1-y
+			case orientTranspose:
+				sx, sy = y, x
+			case orientRotate90:
+				sx, sy = y, sh-1-x
+			case orientTransverse:
+				sx, sy = sw-1-y, sh-1-x
+			case orientRotate270:
+				sx, sy = sw-1-y, x
+			}
+			si, di := src.PixOffset(sx, sy), dst.PixOffset(x, y)
+			copy(dst.Pix[di:di+4], src.Pix[si:si+4])
+		}
+	}
+	return dst
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// ifd returns a TIFF structure with a first IFD holding a single entry.
+func ifd(order string, tag, typ uint16, value uint32) []byte {
+	var bo binary.AppendByteOrder = binary.LittleEndian
+	if order == "MM" {
+		bo = binary.BigEndian
+	}
+	b := []byte(order)
+	b = bo.AppendUint16(b, 42)
+	b = bo.AppendUint32(b, 8) // offset of IFD0
+	b = bo.AppendUint16(b, 1) // number of entries
+	b = bo.AppendUint16(b, tag)
+	b = bo.AppendUint16(b, typ)
+	b = bo.AppendUint32(b, 1) // count
+	if typ == typeShort {
+		b = bo.AppendUint16(b, uint16(value))
+		b = bo.AppendUint16(b, 0)
+	} else {
+		b = bo.AppendUint32(b, value)
+	}
+	return bo.AppendUint32(b, 0) // no next IFD
+}
+
+func TestTIFFOrientation(t *testing.T) {
+	tests := []struct {
+		name string
+		b    []byte
+		want int
+	}{
+		{"little endian", ifd("II", tagOrientation, typeShort, orientRotate90), orientRotate90},
+		{"big endian", ifd("MM", tagOrientation, typeShort, orientRotate270), orientRotate270},
+		{"wrong type", ifd("II", tagOrientation, 4, orientRotate90), 0},
+		{"out of range", ifd("II", tagOrientation, typeShort, 9), 0},
+		{"other tag", ifd("II", 0x0100, typeShort, orientRotate90), 0},
+		{"truncated", ifd("II", tagOrientation, typeShort, orientRotate90)[:16], 0},
+		{"bad offset", []byte("II\x2a\x00\xff\xff\x00\x00"), 0},
+		{"no TIFF", []byte("JFIF\x00\x00\x00\x00"), 0},
+		{"empty", nil, 0},
+	}
+	for _, tt := range tests {
+		if got := tiffOrientation(tt.b); got != tt.want {
+			t.Errorf("%s: tiffOrientation = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSetTIFFOrientation(t *testing.T) {
+	tests := []struct {
+		name    string
+		b       []byte
+		entries int
+	}{
+		{"existing", ifd("II", tagOrientation, typeShort, orientRotate90), 1},
+		{"existing long", ifd("MM", tagOrientation, 4, orientRotate90), 1},
+		{"missing", ifd("II", 0x0100, typeShort, 640), 2},
+		{"new", newExif(orientNormal), 1},
+	}
+	for _, tt := range tests {
+		b, err := setTIFFOrientation(tt.b, orientFlipV)
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		if got := tiffOrientation(b); got != orientFlipV {
+			t.Errorf("%s: orientation = %d, want %d", tt.name, got, orientFlipV)
+		}
+		bo := tiffByteOrder(b)
+		if n := int(bo.Uint16(b[bo.Uint32(b[4:]):])); n != tt.entries {
+			t.Errorf("%s: %d entries, want %d", tt.name, n, tt.entries)
+		}
+	}
+}
+
+// testImage returns a 3x2 image with a distinct color for every pixel.
+func testImage() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	for y := range 2 {
+		for x := range 3 {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 0, 255})
+		}
+	}
+	return img
+}
+
+func TestOrient(t *testing.T) {
+	// Where the top left pixel of the stored image ends up.
+	tests := []struct {
+		o    int
+		w, h int
+		x, y int
+	}{
+		{orientNormal, 3, 2, 0, 0},
+		{orientFlipH, 3, 2, 2, 0},
+		{orientRotate180, 3, 2, 2, 1},
+		{orientFlipV, 3, 2, 0, 1},
+		{orientTranspose, 2, 3, 0, 0},
+		{orientRotate90, 2, 3, 1, 0},
+		{orientTransverse, 2, 3, 1, 2},
+		{orientRotate270, 2, 3, 0, 2},
+	}
+	for _, tt := range tests {
+		img := orient(testImage(), tt.o)
+		if b := img.Bounds(); b.Dx() != tt.w || b.Dy() != tt.h {
+			t.Errorf("orientation %d: size %dx%d, want %dx%d", tt.o, b.Dx(), b.Dy(), tt.w, tt.h)
+			continue
+		}
+		if c := img.At(tt.x, tt.y); c != (color.RGBA{0, 0, 0, 255}) {
+			t.Errorf("orientation %d: pixel at %d,%d is %v", tt.o, tt.x, tt.y, c)
+		}
+		if swapsAxes(tt.o) != (tt.w != 3) {
+			t.Errorf("swapsAxes(%d) = %v", tt.o, swapsAxes(tt.o))
+		}
+	}
+}
+
+func TestComposeOrientation(t *testing.T) {
+	tests := []struct {
+		a, b, want int
+	}{
+		{orientNormal, orientRotate90, orientRotate90},
+		{orientRotate90, orientRotate90, orientRotate180},
+		{orientRotate90, orientRotate270, orientNormal},
+		{orientFlipH, orientFlipH, orientNormal},
+		{orientFlipH, orientFlipV, orientRotate180},
+		{orientRotate90, orientFlipH, orientTranspose},
+		{orientFlipH, orientRotate90, orientTransverse},
+	}
+	for _, tt := range tests {
+		if got := composeOrientation(tt.a, tt.b); got != tt.want {
+			t.Errorf("composeOrientation(%d, %d) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+
+	// Applying two orientations one after the other has to look
+	// the same as applying their composition.
+	for a := orientNormal; a <= orientRotate270; a++ {
+		for b := orientNormal; b <= orientRotate270; b++ {
+			want := orient(orient(testImage(), a), b)
+			got := orient(testImage(), composeOrientation(a, b))
+			if !sameImage(got, want) {
+				t.Errorf("orientation %d then %d differs from %d", a, b, composeOrientation(a, b))
+			}
+		}
+	}
+}
+
+func sameImage(a, b image.Image) bool {
+	if a.Bounds() != b.Bounds() {
+		return false
+	}
+	r := a.Bounds()
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if a.At(x, y) != b.At(x, y) {
+				return false
+			}
+		}
+	}
+	return true
+}
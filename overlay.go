@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+
+	"golang.org/x/term"
+)
+
+// showPager displays lines in a scrollable full screen overlay
+// until the user quits it. The keys in closers close it as well.
+// It returns the key it was closed with.
+func showPager(in *input, title string, lines []string, closers string) (rune, error) {
+	top := 0
+	for {
+		cols, rows, err := term.GetSize(int(os.Stdout.Fd()))
+		if err != nil {
+			return 0, err
+		}
+		height := max(rows-2, 1)
+		top = min(max(top, 0), max(len(lines)-height, 0))
+
+		clear()
+		printAt(1, 1, truncateWidth(title, cols))
+		for i := 0; i < height && top+i < len(lines); i++ {
+			printAt(2+i, 1, truncateWidth(lines[top+i], cols))
+		}
+		footer := "j/k scroll, q quit"
+		if len(lines) > height {
+			footer = fmt.Sprintf("%d-%d/%d  %s", top+1, min(top+height, len(lines)), len(lines), footer)
+		}
+		printAt(rows, 1, truncateWidth(footer, cols))
+
+		key, count, err := in.readKey()
+		if err != nil {
+			return 0, err
+		}
+		if strings.ContainsRune(closers, key) {
+			clear()
+			return key, nil
+		}
+		switch key {
+		case 'q', '\033':
+			clear()
+			return key, nil
+		case 'j':
+			top += max(count, 1)
+		case 'k':
+			top -= max(count, 1)
+		case ' ', 'f':
+			top += height
+		case 'b':
+			top -= height
+		case 'g':
+			top = 0
+		case 'G':
+			top = len(lines)
+		}
+	}
+}
+
+// readCommand reads a command typed after ':' in the given row.
+// It returns "" if the prompt was cancelled.
+func readCommand(in *input, row int) (string, error) {
+	showCursor()
+	defer hideCursor()
+
+	var buf []rune
+	for {
+		moveCursor(row, 1)
+		clearLine()
+		fmt.Print(":" + string(buf))
+
+		ch, err := in.readRune()
+		if err != nil {
+			return "", err
+		}
+		switch ch {
+		case '\r', '\n':
+			return string(buf), nil
+		case '\033':
+			return "", nil
+		case 127, '\b':
+			if len(buf) == 0 {
+				return "", nil
+			}
+			buf = buf[:len(buf)-1]
+		case 21: // ^U
+			buf = buf[:0]
+		default:
+			if unicode.IsPrint(ch) {
+				buf = append(buf, ch)
+			}
+		}
+	}
+}
+
+// truncateWidth shortens s to at most w columns.
+func truncateWidth(s string, w int) string {
+	if displayWidth(s) <= w {
+		return s
+	}
+	out := make([]rune, 0, w)
+	width := 0
+	for _, r := range s {
+		rw := runeWidth(r)
+		if width+rw > w {
+			break
+		}
+		out = append(out, r)
+		width += rw
+	}
+	return string(out)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// Playback speed is doubled or halved within these limits.
+const (
+	minSpeed = 1.0 / 8
+	maxSpeed = 8.0
+)
+
+// animResult carries an animation decoded in the background.
+type animResult struct {
+	pic  *picture
+	anim *animation
+	err  error
+}
+
+// loadAnimation decodes the animation of pic and sends it to results.
+func loadAnimation(pic *picture, results chan<- animResult) {
+	rc, err := pic.open()
+	if err != nil {
+		results <- animResult{pic: pic, err: err}
+		return
+	}
+	data, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		results <- animResult{pic: pic, err: err}
+		return
+	}
+	anim, err := decodeAnimation(data, pic.format)
+	results <- animResult{pic: pic, anim: anim, err: err}
+}
+
+// player plays an animation using a built-in renderer.
+type player struct {
+	pic  *picture
+	anim *animation
+	a    area
+	// out caches the rendered frames.
+	out    [][]byte
+	frame  int
+	played int
+	paused bool
+	// due is when the next frame has to be shown.
+	due time.Time
+}
+
+func newPlayer(pic *picture, anim *animation, a area, speed float64) *player {
+	p := &player{
+		pic:  pic,
+		anim: anim,
+		a:    a,
+		out:  make([][]byte, len(anim.frames)),
+	}
+	p.schedule(time.Now(), speed)
+	return p
+}
+
+// ended reports whether the animation has been played as often as intended.
+func (p *player) ended() bool {
+	return p.anim.plays > 0 && p.played >= p.anim.plays
+}
+
+// schedule sets when the frame after the current one is due.
+func (p *player) schedule(from time.Time, speed float64) {
+	delay := p.anim.frames[p.frame].delay
+	p.due = from.Add(time.Duration(float64(delay) / speed))
+}
+
+// advance moves to the next frame once it is due.
+func (p *player) advance(speed float64) {
+	if p.frame+1 == len(p.anim.frames) {
+		p.played++
+		if p.ended() {
+			p.paused = true
+			return
+		}
+	}
+	p.frame = (p.frame + 1) % len(p.anim.frames)
+	// Don't try to catch up if we fell behind, e.g. while the help was shown.
+	from := p.due
+	if now := time.Now(); now.Sub(from) > time.Second {
+		from = now
+	}
+	p.schedule(from, speed)
+}
+
+// step shows the frame delta frames away and pauses playback.
+func (p *player) step(delta int) {
+	p.paused = true
+	p.frame = move(p.frame, len(p.anim.frames), delta, true)
+}
+
+// toggle pauses or resumes playback, restarting ended animations.
+func (p *player) toggle(speed float64) {
+	p.paused = !p.paused
+	if p.paused {
+		return
+	}
+	if p.ended() {
+		p.played = 0
+		p.frame = 0
+	}
+	p.schedule(time.Now(), speed)
+}
+
+// render returns the output drawing the current frame.
+func (p *player) render(r renderer) []byte {
+	if p.out[p.frame] == nil {
+		img := orient(p.anim.frames[p.frame].img, p.pic.displayOrientation())
+		p.out[p.frame] = r.encode(img, p.a, fitArea(img, p.a))
+	}
+	return p.out[p.frame]
+}
+
+// status describes the state of playback for the statusline.
+func (p *player) status(speed float64) string {
+	s := fmt.Sprintf("frame %d/%d", p.frame+1, len(p.anim.frames))
+	if speed != 1 {
+		s += fmt.Sprintf(" %gx", speed)
+	}
+	if p.paused {
+		s += " paused"
+	}
+	return s
+}
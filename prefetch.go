@@ -0,0 +1,178 @@
+package main
+
+import (
+	"container/list"
+	"io"
+	"sync"
+)
+
+// prefetchWorkers bounds the number of images decoded concurrently.
+// Unlike loading metadata, decoding is mostly CPU (and memory) bound.
+const prefetchWorkers = 2
+
+// maxPrefetchEntries bounds the number of cached entries, since warming
+// files for external previewers doesn't count against the memory budget.
+const maxPrefetchEntries = 256
+
+// prefetchKey identifies a rendering of a picture.
+// A resized terminal, a rotated picture or a modified file
+// result in a different key.
+type prefetchKey struct {
+	pic         *picture
+	a           area
+	orientation int
+}
+
+type prefetchEntry struct {
+	key  prefetchKey
+	data []byte
+}
+
+// prefetcher prepares pictures before they are shown, so flipping
+// to them doesn't wait on disks and decoders.
+//
+// With a built-in renderer, pictures are decoded and encoded to terminal
+// output, which is kept in memory up to a budget, evicting the least
+// recently used entries. External previewers have to read files by
+// themselves, so all we can do for them is warming the OS file cache.
+type prefetcher struct {
+	render renderer
+	budget int
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	queue    []prefetchKey
+	entries  map[prefetchKey]*list.Element
+	lru      *list.List // of *prefetchEntry, most recently used first
+	used     int
+	inflight map[prefetchKey]chan struct{}
+}
+
+// newPrefetcher returns a prefetcher using at most budget bytes.
+// render may be nil when using an external previewer.
+func newPrefetcher(render renderer, budget int) *prefetcher {
+	p := &prefetcher{
+		render:   render,
+		budget:   budget,
+		entries:  make(map[prefetchKey]*list.Element),
+		lru:      list.New(),
+		inflight: make(map[prefetchKey]chan struct{}),
+	}
+	p.cond = sync.NewCond(&p.mu)
+	for range prefetchWorkers {
+		go p.work()
+	}
+	return p
+}
+
+// request replaces the queue with keys, in order of priority.
+func (p *prefetcher) request(keys []prefetchKey) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.queue = p.queue[:0]
+	for _, k := range keys {
+		if p.entries[k] == nil && p.inflight[k] == nil {
+			p.queue = append(p.queue, k)
+		}
+	}
+	p.cond.Broadcast()
+}
+
+// get returns the terminal output drawing pic into a.
+// It waits for a worker already preparing it, or renders it itself.
+func (p *prefetcher) get(pic *picture, a area) ([]byte, error) {
+	k := prefetchKey{pic, a, pic.displayOrientation()}
+	p.mu.Lock()
+	for {
+		if el := p.entries[k]; el != nil {
+			p.lru.MoveToFront(el)
+			e := el.Value.(*prefetchEntry)
+			p.mu.Unlock()
+			return e.data, nil
+		}
+		done := p.inflight[k]
+		if done == nil {
+			break
+		}
+		p.mu.Unlock()
+		<-done
+		p.mu.Lock()
+	}
+	p.inflight[k] = make(chan struct{})
+	p.mu.Unlock()
+
+	return p.prepare(k)
+}
+
+func (p *prefetcher) work() {
+	for {
+		p.mu.Lock()
+		for len(p.queue) == 0 {
+			p.cond.Wait()
+		}
+		k := p.queue[0]
+		p.queue = p.queue[1:]
+		p.inflight[k] = make(chan struct{})
+		p.mu.Unlock()
+
+		p.prepare(k)
+	}
+}
+
+// prepare renders k and stores the result.
+// The caller must have marked k as in flight.
+func (p *prefetcher) prepare(k prefetchKey) ([]byte, error) {
+	var data []byte
+	var err error
+	if p.render != nil {
+		data, err = p.encode(k)
+	} else {
+		err = warm(k.pic)
+	}
+	if err != nil {
+		debugf("prefetching %s: %s", k.pic.path, err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	close(p.inflight[k])
+	delete(p.inflight, k)
+	// Errors may be temporary, like files still being written.
+	if err != nil {
+		return nil, err
+	}
+
+	e := &prefetchEntry{key: k, data: data}
+	p.entries[k] = p.lru.PushFront(e)
+	p.used += len(data)
+	// Never evict the entry we just added, it is about to be shown.
+	for p.lru.Len() > 1 && (p.used > p.budget || p.lru.Len() > maxPrefetchEntries) {
+		old := p.lru.Remove(p.lru.Back()).(*prefetchEntry)
+		delete(p.entries, old.key)
+		p.used -= len(old.data)
+	}
+	return data, err
+}
+
+// encode renders k on a worker. The orientation is taken from the key,
+// as the picture may be rotated on the main goroutine meanwhile.
+func (p *prefetcher) encode(k prefetchKey) ([]byte, error) {
+	img, err := decodeStored(k.pic)
+	if err != nil {
+		return nil, err
+	}
+	img = orient(img, k.orientation)
+	return p.render.encode(img, k.a, fitArea(img, k.a)), nil
+}
+
+// warm reads pic, so the previewer finds it in the OS file cache.
+func warm(pic *picture) error {
+	rc, err := pic.open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	_, err = io.Copy(io.Discard, rc)
+	return err
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"slices"
+)
+
+// Sort modes.
+const (
+	sortNone   = "none"   // order of the arguments, directories sorted by name
+	sortRandom = "random" // shuffled using the seed
+)
+
+// newRand returns the random number generator used for shuffling
+// and random jumps. The same seed results in the same order.
+func newRand(seed uint64) *rand.Rand {
+	return rand.New(rand.NewPCG(seed, seed))
+}
+
+// shuffle puts pics into random order.
+func shuffle(pics []*picture, r *rand.Rand) {
+	r.Shuffle(len(pics), func(i, j int) {
+		pics[i], pics[j] = pics[j], pics[i]
+	})
+}
+
+// markSeen records pics as shown.
+func (v *viewer) markSeen(pics []*picture) {
+	for _, pic := range pics {
+		v.seen[pic] = true
+	}
+}
+
+// randomUnseen returns the index of a random picture not shown yet.
+// Indexes are drawn from a random order shuffled a step at a time,
+// which starts over if the list changes.
+func (v *viewer) randomUnseen() (int, bool) {
+	for {
+		if len(v.order) != len(v.pics) {
+			v.order = make([]int, len(v.pics))
+			for i := range v.order {
+				v.order[i] = i
+			}
+			v.orderPos = 0
+		}
+		for v.orderPos < len(v.order) {
+			i := v.orderPos + v.rand.IntN(len(v.order)-v.orderPos)
+			v.order[v.orderPos], v.order[i] = v.order[i], v.order[v.orderPos]
+			idx := v.order[v.orderPos]
+			v.orderPos++
+			if !v.seen[v.pics[idx]] {
+				return idx, true
+			}
+		}
+		// Pictures added and removed since the order was made may have
+		// been missed.
+		if !slices.ContainsFunc(v.pics, func(pic *picture) bool { return !v.seen[pic] }) {
+			return 0, false
+		}
+		v.order = nil
+	}
+}
+
+// resetSeen forgets which pictures were shown, except the current ones.
+func (v *viewer) resetSeen() {
+	v.seen = make(map[*picture]bool)
+	v.markSeen(v.pages())
+	v.order = nil
+}
+
+// jumpRandom moves to a random picture not shown yet.
+// Once everything has been seen, it starts over.
+func (v *viewer) jumpRandom() {
+	idx, ok := v.randomUnseen()
+	if !ok {
+		v.resetSeen()
+		if idx, ok = v.randomUnseen(); !ok {
+			return
+		}
+		v.infoMsg = fmt.Sprintf("All %d images seen, starting over", len(v.pics))
+		v.keepMsg = true
+	}
+	v.curr = idx
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+// Built-in renderers, used instead of the previewer command if configured.
+const (
+	rendererKitty  = "kitty"
+	rendererBlocks = "blocks"
+)
+
+// Used if the terminal doesn't report its cell size.
+const (
+	defaultCellWidth  = 8
+	defaultCellHeight = 16
+)
+
+// area is a rectangle of terminal cells an image is drawn into.
+type area struct {
+	x, y       int // 0-based cell offset
+	cols, rows int
+	// cellW and cellH hold the size of a cell in pixels.
+	cellW, cellH int
+}
+
+// renderer turns decoded images into escape sequences for the terminal.
+type renderer interface {
+	// encode returns the output drawing img centered into a,
+	// scaled by scale (screen pixels per image pixel).
+	encode(img image.Image, a area, scale float64) []byte
+	// clear returns the output removing everything drawn so far.
+	clear() []byte
+	// erase returns the output removing images, but not text.
+	// It is used before drawing over an image, e.g. the next frame.
+	erase() []byte
+}
+
+func newRenderer(name string) renderer {
+	switch name {
+	case rendererKitty:
+		return kittyRenderer{}
+	case rendererBlocks:
+		return blockRenderer{}
+	}
+	return nil
+}
+
+// decodePicture decodes the full image of pic, oriented for display.
+// Animations are decoded as their first frame.
+func decodePicture(pic *picture) (image.Image, error) {
+	img, err := decodeStored(pic)
+	if err != nil {
+		return nil, err
+	}
+	return orient(img, pic.displayOrientation()), nil
+}
+
+// decodeStored is like decodePicture, but returns the image as stored,
+// without applying any orientation. It only reads fields of pic that
+// never change, so it is safe to use from other goroutines.
+func decodeStored(pic *picture) (image.Image, error) {
+	rc, err := pic.open()
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		// The webp package doesn't know about animations at all.
+		if anim, animErr := webpAnimation(data); animErr == nil {
+			return anim.frames[0].img, nil
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", pic.name, err)
+	}
+	return img, nil
+}
+
+// fit returns the scale factor making an image of w x h pixels
+// as large as possible while still fitting into maxW x maxH.
+func fit(w, h int, maxW, maxH float64) float64 {
+	if w == 0 || h == 0 {
+		return 0
+	}
+	return math.Min(maxW/float64(w), maxH/float64(h))
+}
+
+// fitArea returns the scale making img as large as possible within a.
+func fitArea(img image.Image, a area) float64 {
+	b := img.Bounds()
+	return fit(b.Dx(), b.Dy(), float64(a.cols*a.cellW), float64(a.rows*a.cellH))
+}
+
+// resize scales img to w x h pixels, starting at the origin.
+// Enlarged pixels are kept sharp, so details can be inspected.
+func resize(img image.Image, w, h int) image.Image {
+	b := img.Bounds()
+	if b.Dx() == w && b.Dy() == h && b.Min == (image.Point{}) {
+		return img
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	var scaler draw.Scaler = draw.ApproxBiLinear
+	if w > b.Dx() {
+		scaler = draw.NearestNeighbor
+	}
+	scaler.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+// kittyRenderer implements the kitty graphics protocol.
+// See https://sw.kovidgoyal.net/kitty/graphics-protocol/
+type kittyRenderer struct{}
+
+func (kittyRenderer) encode(img image.Image, a area, scale float64) []byte {
+	b := img.Bounds()
+	cols := max(min(int(math.Round(float64(b.Dx())*scale/float64(a.cellW))), a.cols), 1)
+	rows := max(min(int(math.Round(float64(b.Dy())*scale/float64(a.cellH))), a.rows), 1)
+
+	// Upscaling is left to the terminal, there is no point in sending
+	// more pixels than the image has.
+	if scale < 1 {
+		img = resize(img, max(int(float64(b.Dx())*scale), 1), max(int(float64(b.Dy())*scale), 1))
+		b = img.Bounds()
+	}
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, b.Min, draw.Src)
+
+	var z bytes.Buffer
+	zw, _ := zlib.NewWriterLevel(&z, zlib.BestSpeed)
+	zw.Write(rgba.Pix)
+	zw.Close()
+	payload := base64.StdEncoding.EncodeToString(z.Bytes())
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "\033[%d;%dH", a.y+(a.rows-rows)/2+1, a.x+(a.cols-cols)/2+1)
+	const chunk = 4096
+	for i := 0; i < len(payload); i += chunk {
+		end := min(i+chunk, len(payload))
+		more := 0
+		if end < len(payload) {
+			more = 1
+		}
+		if i == 0 {
+			// q=2 suppresses responses, which would end up as input.
+			fmt.Fprintf(&out, "\033_Ga=T,f=32,o=z,s=%d,v=%d,c=%d,r=%d,C=1,q=2,m=%d;",
+				b.Dx(), b.Dy(), cols, rows, more)
+		} else {
+			fmt.Fprintf(&out, "\033_Gm=%d;", more)
+		}
+		out.WriteString(payload[i:end])
+		out.WriteString("\033\\")
+	}
+	return out.Bytes()
+}
+
+func (r kittyRenderer) clear() []byte {
+	return append(r.erase(), "\033[2J"...)
+}
+
+func (kittyRenderer) erase() []byte {
+	return []byte("\033_Ga=d,d=A,q=2\033\\")
+}
+
+// blockRenderer draws images using Unicode half blocks and 24-bit colors,
+// which works in most terminals without any image protocol.
+// Every cell holds two vertically stacked pixels.
+type blockRenderer struct{}
+
+func (blockRenderer) encode(img image.Image, a area, scale float64) []byte {
+	b := img.Bounds()
+	pxW, pxH := float64(a.cellW), float64(a.cellH)/2
+	w := max(min(int(math.Round(float64(b.Dx())*scale/pxW)), a.cols), 1)
+	h := max(min(int(math.Round(float64(b.Dy())*scale/pxH)), a.rows*2), 1)
+	img = resize(img, w, h)
+
+	x0 := a.x + (a.cols-w)/2 + 1
+	y0 := a.y + (a.rows-(h+1)/2)/2 + 1
+
+	var out bytes.Buffer
+	for y := 0; y < h; y += 2 {
+		fmt.Fprintf(&out, "\033[%d;%dH", y0+y/2, x0)
+		for x := range w {
+			top := opaque(img.At(x, y))
+			if y+1 < h {
+				bottom := opaque(img.At(x, y+1))
+				fmt.Fprintf(&out, "\033[38;2;%d;%d;%dm\033[48;2;%d;%d;%dm▀",
+					top.R, top.G, top.B, bottom.R, bottom.G, bottom.B)
+			} else {
+				fmt.Fprintf(&out, "\033[49m\033[38;2;%d;%d;%dm▀", top.R, top.G, top.B)
+			}
+		}
+		out.WriteString("\033[0m")
+	}
+	return out.Bytes()
+}
+
+func (blockRenderer) clear() []byte {
+	return []byte("\033[2J")
+}
+
+// erase is a no-op, since every cell gets overwritten anyway.
+func (blockRenderer) erase() []byte {
+	return nil
+}
+
+// opaque returns c composited over black.
+func opaque(c color.Color) color.RGBA {
+	r, g, b, _ := c.RGBA()
+	// Colors are premultiplied, so dropping alpha blends over black.
+	return color.RGBA{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), 0xff}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// skippedFile is an input path that did not make it into the image list.
+type skippedFile struct {
+	path   string
+	reason string
+	// rejected is set for files that were meant to be loaded but failed,
+	// as opposed to files deliberately filtered out.
+	rejected bool
+}
+
+// rejectedFile describes path failing to load because of err.
+func rejectedFile(path string, err error) skippedFile {
+	var reason string
+	var pe *fs.PathError
+	switch {
+	case errors.Is(err, fs.ErrPermission):
+		reason = "permission denied"
+	case errors.Is(err, fs.ErrNotExist):
+		reason = "no such file"
+	case errors.Is(err, os.ErrInvalid):
+		reason = "not a regular file"
+	case errors.Is(err, errNotImage):
+		reason = "not an image"
+	case errors.As(err, &pe) && pe.Op == "decoding":
+		reason = "decode error: " + pe.Err.Error()
+	default:
+		reason = err.Error()
+	}
+	return skippedFile{path: path, reason: reason, rejected: true}
+}
+
+func countRejected(skipped []skippedFile) int {
+	n := 0
+	for _, s := range skipped {
+		if s.rejected {
+			n++
+		}
+	}
+	return n
+}
+
+// skippedSummary is used for the %k statusline expansion.
+// It is empty if nothing was skipped.
+func skippedSummary(skipped []skippedFile) string {
+	if len(skipped) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d skipped", len(skipped))
+}
+
+// skippedLines formats skipped files for display in a pager.
+func skippedLines(skipped []skippedFile) []string {
+	if len(skipped) == 0 {
+		return []string{"Nothing was skipped."}
+	}
+	lines := make([]string, len(skipped))
+	for i, s := range skipped {
+		lines[i] = fmt.Sprintf("%s: %s", s.path, s.reason)
+	}
+	return lines
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"time"
+)
+
+// slideshow advances to the next picture on a timer.
+type slideshow struct {
+	interval time.Duration
+	// due is when the next picture has to be shown.
+	due    time.Time
+	paused bool
+}
+
+func newSlideshow(interval time.Duration) *slideshow {
+	return &slideshow{interval: interval, due: time.Now().Add(interval)}
+}
+
+// pause stops the countdown until resume is called.
+func (s *slideshow) pause() {
+	s.paused = true
+}
+
+// resume restarts the countdown for the current picture.
+func (s *slideshow) resume() {
+	s.paused = false
+	s.due = time.Now().Add(s.interval)
+}
+
+// wait returns how long to wait for the next change of the countdown.
+func (s *slideshow) wait() time.Duration {
+	left := time.Until(s.due)
+	if rem := left % time.Second; rem > 0 {
+		return rem
+	}
+	return min(left, time.Second)
+}
+
+// status describes the countdown for the statusline.
+func (s *slideshow) status() string {
+	if s.paused {
+		return "slideshow paused"
+	}
+	left := max(time.Until(s.due).Seconds(), 0)
+	return fmt.Sprintf("slideshow %ds", int(math.Ceil(left)))
+}
+
+// toggleSlideshow starts, resumes or stops the slideshow.
+func (v *viewer) toggleSlideshow() {
+	switch {
+	case v.slideshow == nil:
+		v.slideshow = newSlideshow(v.opt.slideshow)
+	case v.slideshow.paused:
+		v.slideshow.resume()
+	default:
+		v.slideshow = nil
+	}
+}
+
+// handleSlideshow shows the next picture once it is due,
+// or refreshes the countdown.
+func (v *viewer) handleSlideshow() {
+	s := v.slideshow
+	v.statusDirty = true
+	if time.Now().Before(s.due) {
+		return
+	}
+	switch {
+	case v.opt.shuffle:
+		idx, ok := v.randomUnseen()
+		if !ok {
+			v.resetSeen()
+			idx, ok = v.randomUnseen()
+		}
+		if ok {
+			v.curr = idx
+		}
+	case !v.opt.wrapscroll && slices.Contains(v.pages(), v.pics[len(v.pics)-1]):
+		v.slideshow = nil
+		v.errMsg = "Slideshow finished"
+		return
+	default:
+		v.step(1)
+	}
+	s.due = time.Now().Add(s.interval)
+}
@@ -0,0 +1,61 @@
+package main
+
+// Spread modes for reading comics and storyboards two pages at a time.
+const (
+	spreadNone = "none"
+	spreadLTR  = "ltr"
+	spreadRTL  = "rtl"
+)
+
+// isWide reports whether pic should be shown on its own in spread mode.
+func isWide(pic *picture) bool {
+	return pic.width > pic.height
+}
+
+// spreads returns the index of the first page of every spread.
+// Covers and wide pages are shown on their own, everything else is paired.
+// Pages whose dimensions are not known yet are assumed to be narrow.
+func spreads(pics []*picture, cover bool) []int {
+	var starts []int
+	for i := 0; i < len(pics); {
+		starts = append(starts, i)
+		if i == 0 && cover || i+1 == len(pics) || isWide(pics[i]) || isWide(pics[i+1]) {
+			i++
+		} else {
+			i += 2
+		}
+	}
+	return starts
+}
+
+// spreadIndex returns the index of the spread in starts containing pics[idx].
+func spreadIndex(starts []int, idx int) int {
+	s := 0
+	for i, start := range starts {
+		if start > idx {
+			break
+		}
+		s = i
+	}
+	return s
+}
+
+// spreadPages returns the pictures of the spread containing pics[idx],
+// ordered from left to right.
+func spreadPages(pics []*picture, idx int, mode string, cover bool) []*picture {
+	starts := spreads(pics, cover)
+	s := spreadIndex(starts, idx)
+	end := len(pics)
+	if s+1 < len(starts) {
+		end = starts[s+1]
+	}
+	pages := []*picture{pics[starts[s]]}
+	if end-starts[s] == 2 {
+		if mode == spreadRTL {
+			pages = []*picture{pics[starts[s]+1], pics[starts[s]]}
+		} else {
+			pages = append(pages, pics[starts[s]+1])
+		}
+	}
+	return pages
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"image"
+	"image/png"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+// thumbWorkers bounds the number of thumbnails generated concurrently.
+const thumbWorkers = 4
+
+type thumbSize struct {
+	dir  string
+	size int
+}
+
+// thumbSizes maps the directories of the freedesktop thumbnail cache
+// to the size of the thumbnails they hold.
+// See https://specifications.freedesktop.org/thumbnail-spec/latest/
+var thumbSizes = []thumbSize{
+	{"normal", 128},
+	{"large", 256},
+	{"x-large", 512},
+	{"xx-large", 1024},
+}
+
+// thumbResult carries a thumbnail generated in the background.
+type thumbResult struct {
+	pic *picture
+	// img is oriented according to the Exif orientation of pic.
+	img image.Image
+	// path is the thumbnail on disk, "" if it isn't cached.
+	path string
+	err  error
+}
+
+// thumbnailer generates thumbnails on demand, caching them on disk
+// following the freedesktop thumbnail specification.
+// Like loader, only the most recent request is worked on.
+type thumbnailer struct {
+	// dir is the cache directory, "" if thumbnails can't be cached.
+	dir     string
+	size    int
+	results chan thumbResult
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	queue    []*picture
+	inflight map[*picture]bool
+}
+
+// newThumbnailer returns a thumbnailer for thumbnails of at least size pixels.
+func newThumbnailer(size int) *thumbnailer {
+	t := &thumbnailer{
+		results:  make(chan thumbResult, thumbWorkers),
+		inflight: make(map[*picture]bool),
+	}
+	t.cond = sync.NewCond(&t.mu)
+
+	i := slices.IndexFunc(thumbSizes, func(s thumbSize) bool {
+		return s.size >= size
+	})
+	if i < 0 {
+		i = len(thumbSizes) - 1
+	}
+	t.size = thumbSizes[i].size
+	if base := cacheDir(); base != "" {
+		t.dir = filepath.Join(base, "thumbnails", thumbSizes[i].dir)
+	}
+
+	for range thumbWorkers {
+		go t.work()
+	}
+	return t
+}
+
+// request replaces the queue with pics, in order of priority.
+// It must be called from the goroutine owning pics.
+func (t *thumbnailer) request(pics []*picture) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.queue = t.queue[:0]
+	for _, p := range pics {
+		if !t.inflight[p] {
+			t.queue = append(t.queue, p)
+		}
+	}
+	t.cond.Broadcast()
+}
+
+func (t *thumbnailer) work() {
+	for {
+		t.mu.Lock()
+		for len(t.queue) == 0 {
+			t.cond.Wait()
+		}
+		p := t.queue[0]
+		t.queue = t.queue[1:]
+		t.inflight[p] = true
+		t.mu.Unlock()
+
+		res := t.thumbnail(p)
+
+		t.mu.Lock()
+		delete(t.inflight, p)
+		t.mu.Unlock()
+		t.results <- res
+	}
+}
+
+// thumbnail returns the cached thumbnail of pic, generating it if necessary.
+func (t *thumbnailer) thumbnail(pic *picture) thumbResult {
+	// Archive entries have no URI to refer to them by.
+	var uri, path, mtime string
+	if pic.archive == "" && t.dir != "" {
+		info, err := os.Stat(pic.path)
+		if err != nil {
+			return thumbResult{pic: pic, err: err}
+		}
+		uri = (&url.URL{Scheme: "file", Path: filepath.ToSlash(pic.path)}).String()
+		sum := md5.Sum([]byte(uri))
+		path = filepath.Join(t.dir, hex.EncodeToString(sum[:])+".png")
+		mtime = strconv.FormatInt(info.ModTime().Unix(), 10)
+
+		if img, ok := readThumb(path, uri, mtime); ok {
+			return thumbResult{pic: pic, img: img, path: path}
+		}
+	}
+
+	img, err := decodeStored(pic)
+	if err != nil {
+		return thumbResult{pic: pic, err: err}
+	}
+	img = orient(img, pic.orientation)
+	b := img.Bounds()
+	// Thumbnails are never larger than the image itself.
+	if scale := fit(b.Dx(), b.Dy(), float64(t.size), float64(t.size)); scale < 1 {
+		img = resize(img, max(int(float64(b.Dx())*scale), 1), max(int(float64(b.Dy())*scale), 1))
+	}
+	if path == "" {
+		return thumbResult{pic: pic, img: img}
+	}
+
+	if err := writeThumb(path, img, uri, mtime); err != nil {
+		warnf("writing thumbnail: %s", err)
+		path = ""
+	}
+	return thumbResult{pic: pic, img: img, path: path}
+}
+
+// readThumb returns the thumbnail at path if it belongs to uri
+// and is up to date.
+func readThumb(path, uri, mtime string) (image.Image, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	text := pngText(data)
+	if text["Thumb::URI"] != uri || text["Thumb::MTime"] != mtime {
+		return nil, false
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, false
+	}
+	return img, true
+}
+
+// writeThumb stores img at path along with the attributes
+// required by the specification.
+func writeThumb(path string, img image.Image, uri, mtime string) error {
+	var b bytes.Buffer
+	if err := png.Encode(&b, img); err != nil {
+		return err
+	}
+	// Text chunks go right after the header, which is 33 bytes
+	// including the signature.
+	var text bytes.Buffer
+	writeChunk(&text, "tEXt", []byte("Thumb::URI\x00"+uri))
+	writeChunk(&text, "tEXt", []byte("Thumb::MTime\x00"+mtime))
+	writeChunk(&text, "tEXt", []byte("Software\x00spit"))
+	data := slices.Concat(b.Bytes()[:33], text.Bytes(), b.Bytes()[33:])
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	// Write to a temporary file first, as other programs might be
+	// reading the cache at the same time.
+	f, err := os.CreateTemp(filepath.Dir(path), "spit-*.png")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// pngText returns the text chunks of the PNG in data.
+func pngText(data []byte) map[string]string {
+	text := make(map[string]string)
+	pngChunks(bytes.NewReader(data), func(typ string, chunk []byte) bool {
+		if k, v, ok := pngTextChunk(typ, chunk); ok {
+			text[k] = v
+		}
+		return typ != "IEND"
+	})
+	return text
+}
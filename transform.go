@@ -0,0 +1,377 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/webp"
+)
+
+// Every orientation is a horizontal flip (or not) followed by a number of
+// clockwise quarter turns. orientations is indexed by flipped*4 + turns.
+var orientations = [8]int{
+	orientNormal, orientRotate90, orientRotate180, orientRotate270,
+	orientFlipH, orientTransverse, orientFlipV, orientTranspose,
+}
+
+// decompose splits orientation o into its flip and quarter turns.
+func decompose(o int) (bool, int) {
+	for i, v := range orientations {
+		if v == o {
+			return i >= 4, i % 4
+		}
+	}
+	return false, 0
+}
+
+// composeOrientation returns the orientation applying a, then b.
+func composeOrientation(a, b int) int {
+	fa, ra := decompose(a)
+	fb, rb := decompose(b)
+	// Flipping reverses the direction of previous turns.
+	if fb {
+		ra = -ra
+	}
+	i := (ra + rb + 4) % 4
+	if fa != fb {
+		i += 4
+	}
+	return orientations[i]
+}
+
+// transform rotates or flips the picture as displayed by orientation o.
+func (p *picture) transform(o int) {
+	p.userOrientation = composeOrientation(p.userOrientation, o)
+	if swapsAxes(o) {
+		p.width, p.height = p.height, p.width
+	}
+}
+
+// displayOrientation returns the orientation the picture is displayed in,
+// combining its Exif orientation with transformations done by the user.
+func (p *picture) displayOrientation() int {
+	return composeOrientation(p.orientation, p.userOrientation)
+}
+
+// saveOrientation persists the orientation pic is displayed in.
+// Formats supporting Exif only get their orientation updated, which is
+// lossless and keeps all other metadata. BMPs and PNGs are encoded again.
+func saveOrientation(pic *picture) error {
+	if pic.archive != "" {
+		return errors.New("images inside archives can't be saved")
+	}
+	data, err := os.ReadFile(pic.path)
+	if err != nil {
+		return err
+	}
+
+	o := pic.displayOrientation()
+	switch pic.format {
+	case "jpeg":
+		data, err = setJPEGOrientation(data, o)
+	case "png":
+		data, err = reencodePNG(data, o)
+	case "webp":
+		data, err = setWebPOrientation(data, o)
+	case "tiff":
+		data, err = setTIFFOrientation(data, o)
+	case "bmp":
+		data, err = reencodeBMP(data, o)
+	case "gif":
+		err = errors.New("GIFs can't store an orientation")
+	default:
+		err = fmt.Errorf("saving %s images is not supported", cmp.Or(pic.format, "unknown"))
+	}
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(pic.path, data); err != nil {
+		return err
+	}
+
+	if pic.format == "bmp" || pic.format == "png" {
+		pic.orientation = 0
+	} else {
+		pic.orientation = o
+	}
+	pic.userOrientation = 0
+	pic.size = int64(len(data))
+	pic.meta = nil
+	return nil
+}
+
+// setJPEGOrientation returns the JPEG in data with orientation o.
+// An Exif segment is added if necessary.
+func setJPEGOrientation(data []byte, o int) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, errors.New("invalid JPEG")
+	}
+	// Pictures without Exif segment get a new one after the JFIF segment,
+	// which has to come first.
+	insertAt := 2
+	for i := 2; i+4 <= len(data) && data[i] == 0xff; {
+		marker := data[i+1]
+		if marker == 0xda || marker == 0xd9 { // SOS, EOI
+			break
+		}
+		n := int(binary.BigEndian.Uint16(data[i+2:]))
+		if n < 2 || i+2+n > len(data) {
+			return nil, errors.New("invalid JPEG segment")
+		}
+		seg := data[i+4 : i+2+n]
+		if marker == 0xe0 {
+			insertAt = i + 2 + n
+		}
+		if block, ok := bytes.CutPrefix(seg, []byte("Exif\x00\x00")); marker == 0xe1 && ok {
+			block, err := setTIFFOrientation(block, o)
+			if err != nil {
+				return nil, err
+			}
+			if 2+len("Exif\x00\x00")+len(block) > math.MaxUint16 {
+				return nil, errors.New("Exif data too large to update")
+			}
+			out := make([]byte, 0, len(data)+len(block)-len(seg))
+			out = append(out, data[:i]...)
+			out = append(out, 0xff, 0xe1)
+			out = binary.BigEndian.AppendUint16(out, uint16(2+len("Exif\x00\x00")+len(block)))
+			out = append(out, "Exif\x00\x00"...)
+			out = append(out, block...)
+			return append(out, data[i+2+n:]...), nil
+		}
+		i += 2 + n
+	}
+
+	exif := append([]byte("Exif\x00\x00"), newExif(o)...)
+	out := make([]byte, 0, len(data)+4+len(exif))
+	out = append(out, data[:insertAt]...)
+	out = append(out, 0xff, 0xe1)
+	out = binary.BigEndian.AppendUint16(out, uint16(2+len(exif)))
+	out = append(out, exif...)
+	return append(out, data[insertAt:]...), nil
+}
+
+// newExif returns an Exif block holding nothing but orientation o.
+func newExif(o int) []byte {
+	var exif bytes.Buffer
+	exif.WriteString("MM\x00\x2a")
+	binary.Write(&exif, binary.BigEndian, uint32(8)) // offset of IFD0
+	binary.Write(&exif, binary.BigEndian, uint16(1)) // number of entries
+	binary.Write(&exif, binary.BigEndian, []uint16{tagOrientation, typeShort})
+	binary.Write(&exif, binary.BigEndian, uint32(1)) // count
+	binary.Write(&exif, binary.BigEndian, []uint16{uint16(o), 0})
+	binary.Write(&exif, binary.BigEndian, uint32(0)) // no next IFD
+	return exif.Bytes()
+}
+
+// reencodePNG returns the PNG in data transformed by orientation o.
+// Many viewers ignore Exif in PNGs, so the pixels are turned instead.
+// Text chunks are kept, and so is Exif data, with its orientation reset.
+func reencodePNG(data []byte, o int) ([]byte, error) {
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	if err := png.Encode(&b, orient(img, o)); err != nil {
+		return nil, err
+	}
+
+	var keep bytes.Buffer
+	pngChunks(bytes.NewReader(data), func(typ string, chunk []byte) bool {
+		switch typ {
+		case "tEXt", "zTXt", "iTXt":
+			writeChunk(&keep, typ, chunk)
+		case "eXIf":
+			if exif, err := setTIFFOrientation(chunk, orientNormal); err == nil {
+				writeChunk(&keep, typ, exif)
+			}
+		}
+		return typ != "IEND"
+	})
+	// The kept chunks go right after the header.
+	const headerLen = 8 + 12 + 13
+	out := b.Bytes()
+	return slices.Concat(out[:headerLen], keep.Bytes(), out[headerLen:]), nil
+}
+
+// setWebPOrientation returns the WebP in data with orientation o.
+// Simple files are turned into extended ones, which can hold an EXIF
+// chunk. All other chunks are kept as they are.
+func setWebPOrientation(data []byte, o int) ([]byte, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, errors.New("invalid WebP")
+	}
+	var body bytes.Buffer
+	body.WriteString("WEBP")
+	found, extended := false, false
+	for typ, chunk := range riffChunks(data[12:]) {
+		switch typ {
+		case "VP8X":
+			if len(chunk) < 10 {
+				return nil, errors.New("invalid VP8X chunk")
+			}
+			chunk = bytes.Clone(chunk)
+			chunk[0] |= 0x08 // EXIF
+			extended = true
+		case "VP8 ", "VP8L":
+			if extended {
+				break
+			}
+			cfg, err := webp.DecodeConfig(bytes.NewReader(data))
+			if err != nil {
+				return nil, err
+			}
+			vp8x := make([]byte, 10)
+			vp8x[0] = 0x08
+			// Lossless images tell whether they use alpha in their header.
+			if typ == "VP8L" && len(chunk) >= 5 && binary.LittleEndian.Uint32(chunk[1:])&(1<<28) != 0 {
+				vp8x[0] |= 0x10
+			}
+			putUint24(vp8x[4:], uint32(cfg.Width-1))
+			putUint24(vp8x[7:], uint32(cfg.Height-1))
+			writeRIFFChunk(&body, "VP8X", vp8x)
+			extended = true
+		case "EXIF":
+			exif, prefixed := bytes.CutPrefix(chunk, []byte("Exif\x00\x00"))
+			exif, err := setTIFFOrientation(exif, o)
+			if err != nil {
+				return nil, err
+			}
+			if prefixed {
+				exif = append([]byte("Exif\x00\x00"), exif...)
+			}
+			chunk, found = exif, true
+		case "XMP ":
+			// EXIF comes before XMP.
+			if !found {
+				writeRIFFChunk(&body, "EXIF", newExif(o))
+				found = true
+			}
+		}
+		writeRIFFChunk(&body, typ, chunk)
+	}
+	if !extended {
+		return nil, errors.New("WebP has no image data")
+	}
+	if !found {
+		writeRIFFChunk(&body, "EXIF", newExif(o))
+	}
+	if uint64(body.Len()) > math.MaxUint32 {
+		return nil, errors.New("WebP too large")
+	}
+	out := make([]byte, 0, 8+body.Len())
+	out = append(out, "RIFF"...)
+	out = binary.LittleEndian.AppendUint32(out, uint32(body.Len()))
+	return append(out, body.Bytes()...), nil
+}
+
+// setTIFFOrientation returns the TIFF structure b, like an Exif block,
+// with orientation o. Without an orientation tag, the first IFD is copied
+// to the end along with one, which keeps all other offsets valid.
+func setTIFFOrientation(b []byte, o int) ([]byte, error) {
+	if bo, pos := orientationEntry(b); pos >= 0 {
+		// Entries of other types are rewritten as well, rather than
+		// adding a second one readers might prefer.
+		out := bytes.Clone(b)
+		bo.PutUint16(out[pos+2:], typeShort)
+		bo.PutUint32(out[pos+4:], 1)
+		bo.PutUint16(out[pos+8:], uint16(o))
+		bo.PutUint16(out[pos+10:], 0)
+		return out, nil
+	}
+
+	errInvalid := errors.New("invalid Exif data")
+	bo := tiffByteOrder(b)
+	if bo == nil {
+		return nil, errInvalid
+	}
+	off := uint64(bo.Uint32(b[4:]))
+	if off+2 > uint64(len(b)) {
+		return nil, errInvalid
+	}
+	n := uint64(bo.Uint16(b[off:]))
+	end := off + 2 + 12*n
+	if end+4 > uint64(len(b)) || n == math.MaxUint16 {
+		return nil, errInvalid
+	}
+
+	entry := make([]byte, 12)
+	bo.PutUint16(entry, tagOrientation)
+	bo.PutUint16(entry[2:], typeShort)
+	bo.PutUint32(entry[4:], 1)
+	bo.PutUint16(entry[8:], uint16(o))
+
+	out := bytes.Clone(b)
+	// IFDs start on a word boundary.
+	if len(out)%2 == 1 {
+		out = append(out, 0)
+	}
+	if uint64(len(out)) > math.MaxUint32 {
+		return nil, errors.New("Exif data too large to update")
+	}
+	bo.PutUint32(out[4:], uint32(len(out)))
+	count := make([]byte, 2)
+	bo.PutUint16(count, uint16(n+1))
+	out = append(out, count...)
+	// Entries are sorted by tag.
+	added := false
+	for e := off + 2; e < end; e += 12 {
+		if !added && bo.Uint16(b[e:]) > tagOrientation {
+			out = append(out, entry...)
+			added = true
+		}
+		out = append(out, b[e:e+12]...)
+	}
+	if !added {
+		out = append(out, entry...)
+	}
+	return append(out, b[end:end+4]...), nil // offset of the next IFD
+}
+
+// reencodeBMP returns the BMP in data transformed by orientation o.
+// BMPs hold no metadata, so nothing is lost.
+func reencodeBMP(data []byte, o int) ([]byte, error) {
+	img, err := bmp.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	if err := bmp.Encode(&b, orient(img, o)); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// writeFileAtomic replaces the file at path with data, keeping its permissions.
+func writeFileAtomic(path string, data []byte) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".spit-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(info.Mode().Perm()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
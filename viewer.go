@@ -0,0 +1,1082 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/term"
+)
+
+// viewer holds the state of the interactive session.
+type viewer struct {
+	opt     options
+	pics    []*picture
+	curr    int
+	skipped []skippedFile
+	filter  *pathFilter
+	cache   *metaCache
+
+	loader *loader
+	// render is nil unless a built-in renderer is used.
+	render   renderer
+	prefetch *prefetcher
+	// dir is the direction of the last move, 1 or -1.
+	dir int
+	// zoom is nil unless in zoom mode.
+	zoom *zoom
+	// zoomed delivers pictures decoded for zoom and compare mode.
+	zoomed chan zoomResult
+	// fellBack is set while the screen shows the output of the renderer
+	// zoomRenderer falls back to.
+	fellBack bool
+	// player is nil unless an animation is playing.
+	player *player
+	anims  chan animResult
+	// speed applies to all animations.
+	speed float64
+	// compare is nil unless in compare mode.
+	compare *compare
+	// diff is nil unless in diff mode.
+	diff  *diffView
+	diffs chan diffResult
+	// stats delivers histograms, statsPending is the picture
+	// one is being computed for.
+	stats        chan statsResult
+	statsPending *picture
+	// hashes delivers checksums for the info panel, hashPending is
+	// the picture one is being computed for.
+	hashes      chan hashResult
+	hashPending *picture
+	// metas delivers the metadata read for the statusline,
+	// metaPending is the picture being read.
+	metas       chan metaResult
+	metaPending *picture
+	// gallery is nil unless in gallery mode.
+	gallery *gallery
+	// thumbs is created when the gallery is opened first.
+	thumbs     *thumbnailer
+	thumbCache map[*picture]thumbResult
+	// rand is seeded by -seed, so random orders can be reproduced.
+	rand *rand.Rand
+	// seen holds the pictures shown so far.
+	seen map[*picture]bool
+	// order holds indexes of pics in random order, used for random
+	// jumps up to orderPos.
+	order    []int
+	orderPos int
+	// marked counts the pictures marked.
+	marked int
+	// scan is where loading the remaining pictures in the background
+	// goes on. Everything before it is loaded.
+	scan int
+	// slideshow is nil unless a slideshow is running or paused.
+	slideshow *slideshow
+	// watch is nil unless directories are being watched.
+	watch <-chan watchEvent
+
+	cols, rows int
+	// shown holds the pictures currently on screen.
+	shown   []*picture
+	extract extractor
+	// errMsg replaces the statusline until the next picture is shown.
+	errMsg string
+	// infoMsg does the same for messages that aren't errors.
+	infoMsg string
+	// keepMsg keeps the messages when showing the next picture,
+	// since they are about moving to it.
+	keepMsg     bool
+	statusDirty bool
+}
+
+// handleLoaded applies the result of a background load.
+// Pictures that failed to load are removed from the list.
+func (v *viewer) handleLoaded(res loadResult) {
+	v.statusDirty = true
+	// Pictures removed meanwhile don't matter, so only rejected ones
+	// have to be looked up.
+	if res.err == nil {
+		res.pic.apply(res.meta)
+		return
+	}
+	idx := slices.Index(v.pics, res.pic)
+	if idx < 0 {
+		return
+	}
+
+	warnp(res.err)
+	v.skipped = append(v.skipped, rejectedFile(res.pic.path, res.err))
+	v.remove(idx)
+}
+
+// loadFirst loads the first n pictures, waiting for them.
+// Rejected pictures are replaced by the ones following them.
+func (v *viewer) loadFirst(n int) {
+	for {
+		unloaded := slices.DeleteFunc(slices.Clone(v.pics[:min(n, len(v.pics))]), func(p *picture) bool {
+			return p.loaded
+		})
+		if len(unloaded) == 0 {
+			return
+		}
+		v.loader.request(unloaded)
+		for range unloaded {
+			v.handleLoaded(<-v.loader.results)
+		}
+	}
+}
+
+// handleWatch updates the list for a file that changed on disk.
+func (v *viewer) handleWatch(ev watchEvent) {
+	v.statusDirty = true
+	idx := slices.IndexFunc(v.pics, func(p *picture) bool {
+		return p.path == ev.path
+	})
+	if ev.removed {
+		if idx >= 0 {
+			debugf("removed: %s", ev.path)
+			v.remove(idx)
+		}
+		return
+	}
+
+	if idx < 0 {
+		reason := v.filter.entryReason(filepath.Base(ev.path), v.filter.dirRules(filepath.Dir(ev.path)))
+		if reason == "" {
+			reason = v.filter.skipReason(ev.path)
+		}
+		if reason != "" {
+			v.skip(skippedFile{path: ev.path, reason: reason})
+			return
+		}
+	}
+
+	pic, err := newPicture(ev.path, v.opt.detect, v.cache)
+	if err != nil {
+		warnp(err)
+		v.skip(rejectedFile(ev.path, err))
+		if idx >= 0 {
+			v.remove(idx)
+		}
+		return
+	}
+	// Replacing the picture also makes draw run the previewer again
+	// if it is the current one.
+	if idx >= 0 {
+		debugf("modified: %s", ev.path)
+		pic.marked = v.pics[idx].marked
+		v.pics[idx] = pic
+		return
+	}
+
+	debugf("added: %s", ev.path)
+	idx = insertIndex(v.pics, ev.path)
+	v.pics = slices.Insert(v.pics, idx, pic)
+	if idx < v.scan {
+		v.scan++
+	}
+	if v.gallery != nil {
+		v.gallery.drawnTop = -1
+	}
+	if v.opt.autojump {
+		v.curr = idx
+	} else if idx <= v.curr && len(v.pics) > 1 {
+		v.curr++
+	}
+}
+
+// waitForImages keeps the session open while the watched directories
+// hold no images, until new ones are added.
+// It reports whether the user wants to quit.
+func (v *viewer) waitForImages(in *input) (bool, error) {
+	v.zoom, v.player = nil, nil
+	v.gallery, v.compare, v.diff = nil, nil, nil
+	v.errMsg, v.infoMsg = "", ""
+	v.clearScreen()
+	v.shown = nil
+	v.statusDirty = true
+	printAt(v.rows, 1, truncateWidth("No images, waiting for new files", v.cols))
+	for len(v.pics) == 0 {
+		select {
+		case ev, ok := <-v.watch:
+			if !ok {
+				return false, errors.New("no images loaded")
+			}
+			v.handleWatch(ev)
+		case key, ok := <-in.keys:
+			if !ok {
+				return false, in.err
+			}
+			if key == 'q' {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// remove drops pics[idx], keeping the current picture if possible.
+func (v *viewer) remove(idx int) {
+	if v.compare != nil && slices.Contains(v.compare.pics, v.pics[idx]) {
+		v.closeCompare()
+	}
+	if v.diff != nil && (v.diff.a == v.pics[idx] || v.diff.b == v.pics[idx]) {
+		v.closeDiff()
+	}
+	if v.pics[idx].marked {
+		v.marked--
+	}
+	v.pics = slices.Delete(v.pics, idx, idx+1)
+	if idx < v.scan {
+		v.scan--
+	}
+	if idx < v.curr || v.curr == len(v.pics) {
+		v.curr = max(v.curr-1, 0)
+	}
+	if v.gallery != nil {
+		v.gallery.drawnTop = -1
+	}
+}
+
+// skip records s unless the same file was already skipped for that reason.
+func (v *viewer) skip(s skippedFile) {
+	if !slices.Contains(v.skipped, s) {
+		v.skipped = append(v.skipped, s)
+	}
+}
+
+// loop draws the current picture and dispatches events until the user quits.
+func (v *viewer) loop(in *input) error {
+	var err error
+	v.cols, v.rows, err = term.GetSize(int(os.Stdout.Fd()))
+	if err != nil {
+		return err
+	}
+
+	count := 0
+	for {
+		if len(v.pics) == 0 {
+			if v.watch == nil {
+				return errors.New("no images loaded")
+			}
+			quit, err := v.waitForImages(in)
+			if quit || err != nil {
+				return err
+			}
+			continue
+		}
+		v.loader.request(v.wanted())
+		if err := v.draw(); err != nil {
+			return err
+		}
+
+		var tick <-chan time.Time
+		if v.player != nil && !v.player.paused {
+			tick = time.After(time.Until(v.player.due))
+		}
+		var blink <-chan time.Time
+		if v.compare != nil && v.compare.blink && !v.compare.paused {
+			blink = time.After(time.Until(v.compare.due))
+		}
+		var slide <-chan time.Time
+		if v.slideshow != nil && !v.slideshow.paused {
+			slide = time.After(v.slideshow.wait())
+		}
+		var thumbs <-chan thumbResult
+		if v.thumbs != nil {
+			thumbs = v.thumbs.results
+		}
+
+		select {
+		case <-tick:
+			v.player.advance(v.speed)
+			v.drawFrame()
+		case <-slide:
+			v.handleSlideshow()
+		case <-blink:
+			v.compare.advance()
+		case res := <-v.diffs:
+			v.handleDiff(res)
+		case res := <-v.metas:
+			v.handleMetadata(res)
+		case res := <-v.hashes:
+			if err := v.handleHash(in, res); err != nil {
+				return err
+			}
+		case res := <-v.stats:
+			if err := v.handleStats(in, res); err != nil {
+				return err
+			}
+		case res := <-v.zoomed:
+			v.handleZoomed(res)
+		case res := <-v.anims:
+			v.handleAnimation(res)
+		case res := <-thumbs:
+			v.handleThumb(res)
+		case res := <-v.loader.results:
+			v.handleLoaded(res)
+			// Handle everything that is ready at once, so we don't redraw
+			// the statusline for every single file.
+		drain:
+			for {
+				select {
+				case res := <-v.loader.results:
+					v.handleLoaded(res)
+				default:
+					break drain
+				}
+			}
+		case ev, ok := <-v.watch:
+			if !ok {
+				v.watch = nil
+				v.errMsg = "Stopped watching directories"
+				continue
+			}
+			v.handleWatch(ev)
+		case key, ok := <-in.keys:
+			if !ok {
+				return in.err
+			}
+			// Any key pauses the slideshow, so it doesn't move on
+			// while the user is looking at something.
+			if v.slideshow != nil && key != 's' && !v.slideshow.paused {
+				v.slideshow.pause()
+				v.statusDirty = true
+			}
+			if isDigit(key) {
+				count = count*10 + int(key-'0')
+				continue
+			}
+			quit, err := v.handleKey(in, key, count)
+			if quit || err != nil {
+				return err
+			}
+			count = 0
+		}
+	}
+}
+
+// wanted returns the pictures whose metadata is needed next,
+// in order of priority.
+func (v *viewer) wanted() []*picture {
+	pics := window(v.pics, v.curr, v.opt.preload)
+	var want []*picture
+	switch {
+	case v.diff != nil:
+		want = []*picture{v.diff.a, v.diff.b}
+	case v.compare != nil:
+		// Marked pictures can be anywhere in the list.
+		want = slices.Clone(v.compare.pics)
+	case v.gallery != nil:
+		// Thumbnails wait for the metadata of their pictures.
+		want = slices.Clone(v.visible())
+	}
+	// The rest is loaded in the background afterwards, so files that
+	// turn out to be no images don't change %t while browsing.
+	for v.scan < len(v.pics) && v.pics[v.scan].loaded {
+		v.scan++
+	}
+	pics = append(pics, v.pics[v.scan:min(v.scan+loadWorkers*4, len(v.pics))]...)
+	for _, pic := range pics {
+		if !slices.Contains(want, pic) {
+			want = append(want, pic)
+		}
+	}
+	return want
+}
+
+// handleKey executes the action bound to key.
+// It reports whether the user wants to quit.
+func (v *viewer) handleKey(in *input, key rune, count int) (bool, error) {
+	if v.zoom != nil && v.handleZoomKey(key, count) {
+		v.statusDirty = true
+		return false, nil
+	}
+	if v.gallery != nil && v.handleGalleryKey(key, count) {
+		v.statusDirty = true
+		return false, nil
+	}
+	if v.diff != nil && v.handleDiffKey(key) {
+		v.statusDirty = true
+		return false, nil
+	}
+	if v.compare != nil && v.handleCompareKey(key, count) {
+		v.statusDirty = true
+		return false, nil
+	}
+	total := len(v.pics)
+	switch key {
+	case 'q':
+		return true, nil
+	case 'l', 'j':
+		v.step(max(count, 1))
+	case 'h', 'k':
+		v.step(-max(count, 1))
+	case 'g':
+		// TODO: gg
+		v.curr = 0
+	case 'G':
+		// G jumps to the last image unless it is preceded by a count.
+		if count == 0 {
+			v.curr = total - 1
+		} else {
+			v.curr = min(count, total) - 1
+		}
+	case 'd':
+		switch v.opt.spread {
+		case spreadNone:
+			v.opt.spread = spreadLTR
+		case spreadLTR:
+			v.opt.spread = spreadRTL
+		default:
+			v.opt.spread = spreadNone
+		}
+		v.shown = nil
+		clear()
+	case 'z':
+		pic := v.pics[v.curr]
+		switch {
+		case v.opt.spread != spreadNone:
+			v.errMsg = "Zooming is not available in spread mode"
+		case !pic.loaded || pic.width == 0 || pic.height == 0:
+			v.errMsg = "Zooming requires known image dimensions"
+		default:
+			v.zoom = newZoom(pic)
+			v.shown = nil
+		}
+	case 't':
+		v.openGallery()
+	case 'c':
+		v.openCompare()
+	case 'D':
+		v.openDiff()
+	case 's':
+		v.toggleSlideshow()
+	case 'r':
+		v.jumpRandom()
+	case 'i':
+		pic := v.pics[v.curr]
+		if pic.hash != "" {
+			return false, v.showInfo(in, pic)
+		}
+		// Hashing large files takes a moment.
+		if v.hashPending != pic {
+			v.hashPending = pic
+			v.infoMsg = "Computing checksum..."
+			go hashPicture(pic, v.hashes)
+		}
+	case 'p':
+		pic := v.pics[v.curr]
+		cols, _, err := term.GetSize(int(os.Stdout.Fd()))
+		if err != nil {
+			return false, err
+		}
+		lines := generationLines(pic, cols)
+		if len(lines) == 0 {
+			v.errMsg = "No generation parameters or text chunks"
+			return false, nil
+		}
+		key, err := showPager(in, "Generation: "+pic.name+" (y copies the prompt)", lines, "py")
+		if err != nil {
+			return false, err
+		}
+		v.shown = nil
+		if key == 'y' {
+			v.copyPrompt(pic)
+		}
+	case 'y':
+		v.copyPrompt(v.pics[v.curr])
+	case 'H':
+		// Decoding large images takes a moment.
+		if pic := v.pics[v.curr]; v.statsPending != pic {
+			v.statsPending = pic
+			v.infoMsg = "Computing histogram..."
+			go loadStats(pic, v.stats)
+		}
+	case 'm':
+		v.toggleMark(v.pics[v.curr])
+	case '>', '<', '|', '_':
+		ops := map[rune]int{'>': orientRotate90, '<': orientRotate270, '|': orientFlipH, '_': orientFlipV}
+		for _, pic := range v.pages() {
+			pic.transform(ops[key])
+		}
+		if v.zoom != nil {
+			v.zoom.img = nil
+		}
+		v.shown = nil
+	case ' ':
+		if v.player != nil {
+			v.player.toggle(v.speed)
+		}
+	case '.', ',':
+		if v.player != nil {
+			delta := max(count, 1)
+			if key == ',' {
+				delta = -delta
+			}
+			v.player.step(delta)
+			v.drawFrame()
+		}
+	case ']':
+		v.speed = min(v.speed*2, maxSpeed)
+		if v.player != nil && !v.player.paused {
+			v.player.schedule(time.Now(), v.speed)
+		}
+	case '[':
+		v.speed = max(v.speed/2, minSpeed)
+		if v.player != nil && !v.player.paused {
+			v.player.schedule(time.Now(), v.speed)
+		}
+	case '?':
+		clear()
+		printAt(1, 1, usageLine)
+		for i, line := range strings.Split(helpMessage, "\n") {
+			printAt(2+i, 1, line)
+		}
+		printAt(999, 1, "Press any key to continue...")
+		if _, err := in.readRune(); err != nil {
+			return false, err
+		}
+		clear()
+		v.shown = nil
+	case ':':
+		cmd, err := readCommand(in, v.rows)
+		if err != nil {
+			return false, err
+		}
+		return false, v.runCommand(in, strings.TrimSpace(cmd))
+	}
+	v.statusDirty = true
+	return false, nil
+}
+
+// handleZoomKey executes the action bound to key in zoom mode.
+// It reports whether key was handled.
+func (v *viewer) handleZoomKey(key rune, count int) bool {
+	switch key {
+	case 'z', 'q', '\033':
+		v.zoom = nil
+	default:
+		if !v.zoom.handleKey(v.area(0, v.cols), key, count) {
+			return false
+		}
+	}
+	v.shown = nil
+	return true
+}
+
+// step moves delta images (or spreads) forward.
+func (v *viewer) step(delta int) {
+	if delta < 0 {
+		v.dir = -1
+	} else {
+		v.dir = 1
+	}
+	if v.opt.spread == spreadNone {
+		v.curr = move(v.curr, len(v.pics), delta, v.opt.wrapscroll)
+		return
+	}
+	starts := spreads(v.pics, v.opt.spreadcover)
+	s := move(spreadIndex(starts, v.curr), len(starts), delta, v.opt.wrapscroll)
+	v.curr = starts[s]
+}
+
+// pages returns the pictures to show, ordered from left to right.
+func (v *viewer) pages() []*picture {
+	if v.opt.spread == spreadNone {
+		return []*picture{v.pics[v.curr]}
+	}
+	return spreadPages(v.pics, v.curr, v.opt.spread, v.opt.spreadcover)
+}
+
+// runCommand executes a command entered at the ':' prompt.
+func (v *viewer) runCommand(in *input, cmd string) error {
+	v.statusDirty = true
+	switch cmd {
+	case "":
+	case "skipped":
+		if _, err := showPager(in, "Skipped files", skippedLines(v.skipped), ""); err != nil {
+			return err
+		}
+		v.shown = nil
+	case "save":
+		pic := v.pics[v.curr]
+		if pic.userOrientation <= orientNormal {
+			v.errMsg = "Nothing to save"
+			return nil
+		}
+		if err := saveOrientation(pic); err != nil {
+			errorf("saving %s: %s", pic.path, err)
+			v.errMsg = fmt.Sprintf("Error saving %q: %s", pic.name, err)
+		}
+		v.shown = nil
+	default:
+		v.errMsg = "Unknown command: " + cmd
+	}
+	return nil
+}
+
+// draw shows the current pictures if they changed and refreshes the statusline.
+func (v *viewer) draw() error {
+	if v.gallery != nil {
+		return v.drawGallery()
+	}
+	if v.compare != nil {
+		return v.drawCompare()
+	}
+	if v.diff != nil {
+		return v.drawDiff()
+	}
+	pages := v.pages()
+	if v.zoom != nil && (len(pages) != 1 || pages[0] != v.zoom.pic) {
+		v.zoom = nil
+	}
+	loaded := !slices.ContainsFunc(pages, func(p *picture) bool {
+		return !p.loaded
+	})
+	if slices.Equal(pages, v.shown) || !loaded {
+		if v.statusDirty {
+			v.drawStatus()
+		}
+		return nil
+	}
+	v.shown = pages
+	v.markSeen(pages)
+	v.player = nil
+	if !v.keepMsg {
+		v.errMsg, v.infoMsg = "", ""
+	}
+	v.keepMsg = false
+	if v.opt.title {
+		setTitle("spit - " + v.pics[v.curr].name)
+	}
+
+	var err error
+	v.cols, v.rows, err = term.GetSize(int(os.Stdout.Fd()))
+	if err != nil {
+		return err
+	}
+	// Pages split the screen evenly.
+	width := v.cols / len(pages)
+	r := v.render
+	if v.zoom != nil {
+		r = v.zoomRenderer()
+	}
+	if r != nil {
+		v.drawRendered(r, pages, width)
+		if len(pages) == 1 && v.zoom == nil && isAnimatable(pages[0].format) {
+			go loadAnimation(pages[0], v.anims)
+		}
+	} else {
+		v.drawPreviewed(pages, width)
+	}
+	v.prefetchNeighbours(width)
+	v.drawStatus()
+	return nil
+}
+
+// drawPreviewed shows pages by running the previewer for each of them.
+func (v *viewer) drawPreviewed(pages []*picture, width int) {
+	if v.fellBack {
+		// Cleaners are only meant for the previewer's output.
+		clear()
+		v.fellBack = false
+	}
+	for i, pic := range pages {
+		path, err := v.extract.file(pic)
+		if err != nil {
+			errorf("extracting image: %s", err)
+			v.errMsg = fmt.Sprintf("Error extracting %q", pic.name)
+			continue
+		}
+		if i == 0 {
+			p := v.preview(pic, path, v.area(0, v.cols), v.zoom)
+			if err := execCmd(generateCmd(v.opt.cleaner, p)); err != nil {
+				errorf("cleaning screen: %s", err)
+				v.errMsg = "Error clearing screen"
+			}
+		}
+		moveCursor(1, i*width+1)
+		if err := execCmd(generateCmd(v.opt.previewer, v.preview(pic, path, v.area(i, width), v.zoom))); err != nil {
+			errorf("displaying image: %s", err)
+			v.errMsg = fmt.Sprintf("Error displaying %q", pic.path)
+		}
+	}
+}
+
+// preview returns the expansions for drawing pic at path into a,
+// zoomed by z if it isn't nil.
+func (v *viewer) preview(pic *picture, path string, a area, z *zoom) preview {
+	p := preview{
+		path:            path,
+		orientation:     pic.displayOrientation(),
+		userOrientation: pic.userOrientation,
+		cols:            a.cols,
+		rows:            a.rows + 1, // %r leaves room for the statusline
+		x:               a.x,
+		y:               a.y,
+		zoom:            100,
+	}
+	if z == nil && pic.width > 0 && pic.height > 0 {
+		z = newZoom(pic)
+	}
+	if z != nil {
+		r, scale := z.view(a)
+		p.cropX, p.cropY = r.Min.X, r.Min.Y
+		p.zoom = int(math.Round(scale * 100))
+	}
+	return p
+}
+
+// drawRendered shows pages using the renderer r.
+func (v *viewer) drawRendered(r renderer, pages []*picture, width int) {
+	if r == v.render {
+		os.Stdout.Write(r.clear())
+	} else {
+		// Falling back, the previewer's output has to go.
+		v.clearScreen()
+		v.fellBack = true
+	}
+	for i, pic := range pages {
+		var out []byte
+		var err error
+		if v.zoom != nil {
+			out, err = v.drawZoomed(r, v.zoom, v.area(i, width))
+		} else {
+			out, err = v.prefetch.get(pic, v.area(i, width))
+		}
+		if err != nil {
+			errorf("displaying image: %s", err)
+			v.errMsg = fmt.Sprintf("Error displaying %q", pic.path)
+			continue
+		}
+		os.Stdout.Write(out)
+	}
+}
+
+// drawZoomed returns the output drawing the part of a picture visible
+// through z using r. The picture is decoded in the background first,
+// drawing nothing until it is ready.
+func (v *viewer) drawZoomed(r renderer, z *zoom, a area) ([]byte, error) {
+	if z.err != nil {
+		return nil, z.err
+	}
+	if z.img == nil {
+		if !z.decoding {
+			z.decoding = true
+			go decodeZoom(z, z.pic.displayOrientation(), v.zoomed)
+		}
+		return nil, nil
+	}
+	img, scale := z.crop(a)
+	return r.encode(img, a, scale), nil
+}
+
+// zoomRenderer returns the renderer used for zoomed pictures, or nil
+// if the previewer shows them. Previewers not using any of the crop and
+// zoom expansions can't, so blocks are used instead, like for diffs.
+func (v *viewer) zoomRenderer() renderer {
+	if v.render != nil {
+		return v.render
+	}
+	for _, e := range []string{"%x", "%y", "%z"} {
+		if strings.Contains(v.opt.previewer, e) {
+			return nil
+		}
+	}
+	return blockRenderer{}
+}
+
+// handleZoomed shows a picture once it is decoded for zooming.
+func (v *viewer) handleZoomed(res zoomResult) {
+	z := res.z
+	z.decoding = false
+	switch {
+	case res.err != nil:
+		errorf("decoding image: %s", res.err)
+		z.err = res.err
+	case res.orientation == z.pic.displayOrientation():
+		z.img = res.img
+	}
+	// Pictures rotated meanwhile get decoded again when drawn.
+	if z == v.zoom {
+		v.shown = nil
+	}
+	if c := v.compare; c != nil {
+		if i := slices.Index(c.zooms, z); i >= 0 {
+			c.out[i] = nil
+			c.dirty = true
+		}
+	}
+}
+
+// handleAnimation starts playing an animation once it is decoded,
+// unless the user moved on in the meantime.
+func (v *viewer) handleAnimation(res animResult) {
+	if res.err != nil {
+		if !errors.Is(res.err, errNotAnimated) {
+			errorf("decoding animation: %s", res.err)
+		}
+		return
+	}
+	// Frames are drawn full size, so only plain views can play them.
+	if v.zoom != nil || v.compare != nil || v.diff != nil || v.gallery != nil {
+		return
+	}
+	if res.pic != v.pics[v.curr] || len(v.shown) != 1 || v.shown[0] != res.pic {
+		return
+	}
+	v.player = newPlayer(res.pic, res.anim, v.area(0, v.cols), v.speed)
+	v.statusDirty = true
+}
+
+// drawFrame shows the current frame of the animation.
+func (v *viewer) drawFrame() {
+	os.Stdout.Write(append(v.render.erase(), v.player.render(v.render)...))
+	v.statusDirty = true
+}
+
+// area returns the part of the screen page i is drawn into.
+func (v *viewer) area(i, width int) area {
+	cellW, cellH := cellSize(int(os.Stdout.Fd()))
+	if cellW == 0 || cellH == 0 {
+		cellW, cellH = defaultCellWidth, defaultCellHeight
+	}
+	// The last row belongs to the statusline.
+	return area{x: i * width, cols: width, rows: max(v.rows-1, 1), cellW: cellW, cellH: cellH}
+}
+
+// prefetchNeighbours prepares the pictures shown next, starting with
+// the ones in the direction we are moving in.
+func (v *viewer) prefetchNeighbours(width int) {
+	if v.opt.prefetch == 0 {
+		return
+	}
+	var keys []prefetchKey
+	add := func(idx int) {
+		pages := []*picture{v.pics[idx]}
+		if v.opt.spread != spreadNone {
+			pages = spreadPages(v.pics, idx, v.opt.spread, v.opt.spreadcover)
+		}
+		for i, pic := range pages {
+			// Unknown dimensions might still change the spread layout.
+			if !pic.loaded {
+				continue
+			}
+			k := prefetchKey{pic: pic}
+			if v.render != nil {
+				k.a, k.orientation = v.area(i, width), pic.displayOrientation()
+			}
+			if !slices.Contains(keys, k) {
+				keys = append(keys, k)
+			}
+		}
+	}
+
+	// In spread mode, we move through spreads rather than pictures.
+	var starts []int
+	pos, n := v.curr, len(v.pics)
+	if v.opt.spread != spreadNone {
+		starts = spreads(v.pics, v.opt.spreadcover)
+		pos, n = spreadIndex(starts, v.curr), len(starts)
+	}
+	for _, dir := range []int{v.dir, -v.dir} {
+		for d := 1; d <= v.opt.prefetch; d++ {
+			next := move(pos, n, dir*d, v.opt.wrapscroll)
+			if next == pos {
+				break
+			}
+			if starts != nil {
+				next = starts[next]
+			}
+			add(next)
+		}
+	}
+	v.prefetch.request(keys)
+}
+
+// drawStatus prints either the statusline or the current message.
+func (v *viewer) drawStatus() {
+	v.statusDirty = false
+	if v.errMsg != "" || v.infoMsg != "" {
+		moveCursor(v.rows, 1)
+		clearLine()
+		if v.errMsg != "" {
+			showError(v.opt.errorfmt, v.errMsg, v.rows)
+		} else {
+			printAt(v.rows, 1, truncateWidth(v.infoMsg, v.cols))
+		}
+		return
+	}
+	v.printStatus()
+}
+
+// index returns the 1-based index of the current picture,
+// or the range of pages shown in spread mode.
+func (v *viewer) index() string {
+	pages := v.pages()
+	if len(pages) == 1 {
+		return strconv.Itoa(v.curr + 1)
+	}
+	first := slices.Index(v.pics, pages[0])
+	last := slices.Index(v.pics, pages[1])
+	return fmt.Sprintf("%d-%d", min(first, last)+1, max(first, last)+1)
+}
+
+func (v *viewer) printStatus() {
+	opt := v.opt
+	if opt.statusline == "" {
+		return
+	}
+	pic := v.pics[v.curr]
+	cols, rows := v.cols, v.rows
+
+	var size string
+	if opt.humanreadable {
+		size = fmt.Sprintf("%5s", humanReadable(pic.size))
+	} else {
+		size = fmt.Sprintf("%dB", pic.size)
+	}
+	width, height := strconv.Itoa(pic.width), strconv.Itoa(pic.height)
+	if !pic.loaded {
+		size, width, height = "?", "?", "?"
+	}
+	frame := ""
+	if v.player != nil {
+		frame = v.player.status(v.speed)
+	}
+	zoomed := ""
+	switch {
+	case v.zoom != nil:
+		zoomed = v.zoom.status(v.area(0, cols))
+	case v.compare != nil:
+		zoomed = v.compare.status(v.paneArea(0))
+	}
+	slides := ""
+	if v.slideshow != nil {
+		slides = v.slideshow.status()
+	}
+	loading := ""
+	// Everything from the background scan on is yet to be loaded,
+	// other than pictures the window got to first.
+	if n := max(v.loader.pending(), len(v.pics)-v.scan); n > 0 {
+		loading = fmt.Sprintf("loading %d", n)
+	}
+
+	archive := ""
+	if pic.archive != "" {
+		archive = filepath.Base(pic.archive)
+	}
+
+	r := strings.NewReplacer(
+		"%%", "%",
+		"%a", archive,
+		"%f", pic.name,
+		"%F", pic.format,
+		"%h", height,
+		"%i", v.index(),
+		"%k", skippedSummary(v.skipped),
+		"%l", loading,
+		"%m", v.markedSummary(),
+		"%n", frame,
+		"%s", size,
+		"%S", slides,
+		"%t", strconv.Itoa(len(v.pics)),
+		"%w", width,
+		"%z", zoomed,
+	)
+	// Metadata is read in the background, expanding to nothing until then.
+	hasMeta := strings.Contains(opt.statusline, "%{")
+	var meta *metadata
+	if hasMeta {
+		meta = v.requestMetadata(pic)
+	}
+	s := expandWords(opt.statusline, func(w string) string {
+		if hasMeta {
+			w = expandMetadata(w, meta)
+		}
+		return r.Replace(w)
+	})
+	if pic.loaded && pic.height == 0 && pic.width == 0 {
+		s = strings.ReplaceAll(strings.ReplaceAll(s, "0x0", "N/A"), "0X0", "N/A")
+	}
+
+	gaps := strings.Count(opt.statusline, "%=")
+	excess := (displayWidth(s) - gaps*2) - cols // account for %=
+	if excess > 0 {
+		// try truncating filename if possible
+		if excess < displayWidth(pic.name) {
+			// use runes for slicing to not mess up multi-byte chars
+			repl := opt.truncatechar + string([]rune(pic.name)[excess+displayWidth(opt.truncatechar):])
+			s = strings.Replace(s, pic.name, repl, 1)
+		} else {
+			// if still too long, truncate entire string from the left
+			s = opt.truncatechar + string([]rune(s)[excess+displayWidth(opt.truncatechar):])
+		}
+	}
+
+	free := max(cols-(displayWidth(s)-gaps*2), 0)
+	gapSize, rem := 0, 0
+	if gaps > 0 {
+		gapSize = free / gaps
+		rem = free % gaps
+	}
+
+	parts := strings.Split(s, "%=")
+	var b strings.Builder
+	b.WriteString(parts[0])
+	for i, p := range parts[1:] {
+		spaces := gapSize
+		if i < rem {
+			spaces++
+		}
+		b.WriteString(strings.Repeat(" ", spaces))
+		b.WriteString(p)
+	}
+
+	moveCursor(rows, 1)
+	clearLine()
+	printAt(rows, 1, b.String())
+}
+
+// expandWords expands the whitespace separated words of s. Words
+// expanding to nothing are dropped along with the space before them,
+// so empty expansions don't leave gaps.
+func expandWords(s string, expand func(string) string) string {
+	var b strings.Builder
+	dropSpace := false
+	for s != "" {
+		word := strings.TrimLeftFunc(s, unicode.IsSpace)
+		space := s[:len(s)-len(word)]
+		end := strings.IndexFunc(word, unicode.IsSpace)
+		if end < 0 {
+			end = len(word)
+		}
+		word, s = word[:end], word[end:]
+		if word == "" {
+			b.WriteString(space)
+			break
+		}
+		e := expand(word)
+		switch {
+		case e == "" && space == "" && b.Len() == 0:
+			// There is no space before the first word, so drop the one after.
+			dropSpace = true
+		case e == "":
+		case dropSpace:
+			b.WriteString(e)
+			dropSpace = false
+		default:
+			b.WriteString(space + e)
+		}
+	}
+	return b.String()
+}
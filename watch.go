@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// watchEvent reports a file that was written, moved or deleted
+// in a watched directory.
+type watchEvent struct {
+	path    string
+	removed bool
+}
+
+// watchDirs returns the absolute paths of the directories among args.
+// Other arguments are left out.
+func watchDirs(args []string) []string {
+	var dirs []string
+	for _, dir := range args {
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			continue
+		}
+		if abs, err := filepath.Abs(dir); err == nil {
+			dir = abs
+		}
+		if !slices.Contains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// insertIndex returns the position a new file at path belongs to,
+// keeping files of the same directory sorted by name.
+// Files from unknown directories are appended.
+func insertIndex(pics []*picture, path string) int {
+	dir := filepath.Dir(path)
+	last := -1
+	for i, p := range pics {
+		if filepath.Dir(p.path) != dir {
+			continue
+		}
+		if p.path > path {
+			return i
+		}
+		last = i
+	}
+	if last >= 0 {
+		return last + 1
+	}
+	return len(pics)
+}
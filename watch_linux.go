@@ -0,0 +1,78 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// watcher reports changes to directories using inotify.
+type watcher struct {
+	fd     int
+	dirs   map[int]string
+	events chan watchEvent
+}
+
+func newWatcher(dirs []string) (*watcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+
+	// Files are only reported once they have been written completely,
+	// so previewers never see half written images.
+	const mask = unix.IN_CLOSE_WRITE | unix.IN_MOVED_TO | unix.IN_MOVED_FROM | unix.IN_DELETE
+
+	w := &watcher{
+		fd:     fd,
+		dirs:   make(map[int]string),
+		events: make(chan watchEvent, 64),
+	}
+	for _, dir := range dirs {
+		wd, err := unix.InotifyAddWatch(fd, dir, mask)
+		if err != nil {
+			unix.Close(fd)
+			return nil, &os.PathError{Op: "watch", Path: dir, Err: err}
+		}
+		w.dirs[wd] = dir
+	}
+	go w.run()
+	return w, nil
+}
+
+func (w *watcher) run() {
+	defer close(w.events)
+	defer unix.Close(w.fd)
+
+	buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+	for {
+		n, err := unix.Read(w.fd, buf)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil || n <= 0 {
+			errorf("watching: %v", err)
+			return
+		}
+		for off := 0; off+unix.SizeofInotifyEvent <= n; {
+			ev := (*unix.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			start := off + unix.SizeofInotifyEvent
+			off = start + int(ev.Len)
+
+			dir, ok := w.dirs[int(ev.Wd)]
+			if !ok || ev.Len == 0 || ev.Mask&unix.IN_ISDIR != 0 {
+				continue
+			}
+			name := string(bytes.TrimRight(buf[start:off], "\x00"))
+			w.events <- watchEvent{
+				path:    filepath.Join(dir, name),
+				removed: ev.Mask&(unix.IN_DELETE|unix.IN_MOVED_FROM) != 0,
+			}
+		}
+	}
+}
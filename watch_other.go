@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+type watcher struct {
+	events chan watchEvent
+}
+
+func newWatcher(dirs []string) (*watcher, error) {
+	return nil, errors.New("watching directories is only supported on Linux")
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"slices"
+)
+
+// Zoom modes.
+const (
+	zoomFit     = "fit"     // whole image
+	zoomFill    = "fill"    // fill the screen, cropping the image
+	zoomActual  = "actual"  // one image pixel per screen pixel
+	zoomPercent = "percent" // explicit scale
+)
+
+// zoomLevels are the percentages zooming in and out steps through.
+var zoomLevels = []int{5, 10, 25, 50, 75, 100, 150, 200, 300, 400, 600, 800, 1200, 1600, 3200}
+
+// zoom holds the state of zoom mode for a single picture.
+type zoom struct {
+	pic     *picture
+	mode    string
+	percent int
+	// cx and cy are the center of the view relative to the image size.
+	cx, cy float64
+	// img is the decoded picture, kept while panning around.
+	// decoding is set while it is being decoded, err if that failed.
+	img      image.Image
+	decoding bool
+	err      error
+}
+
+// zoomResult carries a picture decoded in the given orientation for z.
+type zoomResult struct {
+	z           *zoom
+	img         image.Image
+	orientation int
+	err         error
+}
+
+// decodeZoom decodes the picture of z for zooming and sends it to results.
+// It must not touch z, which is owned by the viewer.
+func decodeZoom(z *zoom, orientation int, results chan<- zoomResult) {
+	res := zoomResult{z: z, orientation: orientation}
+	img, err := decodeStored(z.pic)
+	if err != nil {
+		res.err = err
+	} else {
+		res.img = orient(img, orientation)
+	}
+	results <- res
+}
+
+func newZoom(pic *picture) *zoom {
+	return &zoom{pic: pic, mode: zoomFit, cx: 0.5, cy: 0.5}
+}
+
+// scale returns the number of screen pixels per image pixel.
+func (z *zoom) scale(a area) float64 {
+	iw, ih := float64(z.pic.width), float64(z.pic.height)
+	aw, ah := float64(a.cols*a.cellW), float64(a.rows*a.cellH)
+	switch z.mode {
+	case zoomFill:
+		return max(aw/iw, ah/ih)
+	case zoomActual:
+		return 1
+	case zoomPercent:
+		return float64(z.percent) / 100
+	}
+	return fit(z.pic.width, z.pic.height, aw, ah)
+}
+
+// view returns the part of the picture visible in a, in image pixels,
+// along with the scale it is drawn at.
+func (z *zoom) view(a area) (image.Rectangle, float64) {
+	s := z.scale(a)
+	iw, ih := z.pic.width, z.pic.height
+	w := min(iw, max(int(math.Ceil(float64(a.cols*a.cellW)/s)), 1))
+	h := min(ih, max(int(math.Ceil(float64(a.rows*a.cellH)/s)), 1))
+	x := min(max(int(z.cx*float64(iw))-w/2, 0), iw-w)
+	y := min(max(int(z.cy*float64(ih))-h/2, 0), ih-h)
+	return image.Rect(x, y, x+w, y+h), s
+}
+
+// pan moves the view by dx and dy eighths of its size.
+// The view never leaves the picture.
+func (z *zoom) pan(a area, dx, dy int) {
+	r, _ := z.view(a)
+	iw, ih := float64(z.pic.width), float64(z.pic.height)
+	cx := float64(r.Min.X+r.Dx()/2+dx*max(r.Dx()/8, 1)) / iw
+	cy := float64(r.Min.Y+r.Dy()/2+dy*max(r.Dy()/8, 1)) / ih
+	// Clamp using the view, so panning back works right away
+	// after hitting an edge.
+	halfW, halfH := float64(r.Dx())/2/iw, float64(r.Dy())/2/ih
+	z.cx = min(max(cx, halfW), 1-halfW)
+	z.cy = min(max(cy, halfH), 1-halfH)
+}
+
+// step zooms in (positive delta) or out along zoomLevels.
+func (z *zoom) step(a area, delta int) {
+	curr := int(math.Round(z.scale(a) * 100))
+	// i is the first level not below the current one.
+	i, found := slices.BinarySearch(zoomLevels, curr)
+	if delta > 0 {
+		if found {
+			i++
+		}
+		i += delta - 1
+	} else {
+		i += delta
+	}
+	z.setPercent(zoomLevels[min(max(i, 0), len(zoomLevels)-1)])
+}
+
+func (z *zoom) setPercent(p int) {
+	z.mode = zoomPercent
+	z.percent = min(max(p, zoomLevels[0]), zoomLevels[len(zoomLevels)-1])
+}
+
+// handleKey zooms or pans the view in a as bound to key.
+// It reports whether key was handled.
+func (z *zoom) handleKey(a area, key rune, count int) bool {
+	n := max(count, 1)
+	switch key {
+	case 'h':
+		z.pan(a, -n, 0)
+	case 'j':
+		z.pan(a, 0, n)
+	case 'k':
+		z.pan(a, 0, -n)
+	case 'l':
+		z.pan(a, n, 0)
+	case 'H':
+		z.pan(a, -4*n, 0)
+	case 'J':
+		z.pan(a, 0, 4*n)
+	case 'K':
+		z.pan(a, 0, -4*n)
+	case 'L':
+		z.pan(a, 4*n, 0)
+	case '+':
+		z.step(a, n)
+	case '-':
+		z.step(a, -n)
+	case '=':
+		z.mode = zoomFit
+	case 'w':
+		z.mode = zoomFill
+	case 'o':
+		z.mode = zoomActual
+	case '%':
+		// Without a count, there is nothing to do.
+		if count > 0 {
+			z.setPercent(count)
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// status describes the zoom level for the statusline.
+func (z *zoom) status(a area) string {
+	return fmt.Sprintf("zoom %d%%", int(math.Round(z.scale(a)*100)))
+}
+
+// crop returns the part of the decoded picture shown in a.
+func (z *zoom) crop(a area) (image.Image, float64) {
+	r, s := z.view(a)
+	r = r.Add(z.img.Bounds().Min)
+	if sub, ok := z.img.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok {
+		return sub.SubImage(r), s
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(dst, dst.Bounds(), z.img, r.Min, draw.Src)
+	return dst, s
+}